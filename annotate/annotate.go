@@ -0,0 +1,257 @@
+// Package annotate maps structural-variant break points to the genes, transcripts and exons or
+// introns that they fall in, using a GTF or GFF3, and predicts whether two break points give an
+// in-frame gene fusion.
+package annotate
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/Schaudge/ngsutils/db"
+	"github.com/Schaudge/ngsutils/intervals"
+)
+
+// Annotator finds the transcripts at a position.
+type Annotator struct {
+	// idx is keyed by chromosome without a "chr" prefix.
+	idx *intervals.Index[*Transcript]
+}
+
+// New indexes the transcripts.
+func New(ts []*Transcript) *Annotator {
+	idx := intervals.NewIndex[*Transcript](intervals.StripChr)
+	for _, t := range ts {
+		idx.Add(t.Chrom, t.Start, t.End, t)
+	}
+	idx.Index()
+	return &Annotator{idx: idx}
+}
+
+// Load reads and indexes the transcripts in a GTF or GFF3.
+func Load(path string) (*Annotator, error) {
+	ts, err := ReadTranscripts(path)
+	if err != nil {
+		return nil, err
+	}
+	return New(ts), nil
+}
+
+// Transcripts gives the transcripts that contain the 0-based position pos on chrom. Chromosome
+// names are matched with or without a "chr" prefix.
+func (a *Annotator) Transcripts(chrom string, pos int) []*Transcript {
+	var out []*Transcript
+	for _, iv := range a.idx.Overlapping(chrom, pos, pos+1) {
+		out = append(out, iv.Value)
+	}
+	return out
+}
+
+// Hit is the position of a break point in a transcript.
+type Hit struct {
+	*Transcript
+	// Pos is the 0-based position of the base that was annotated.
+	Pos int
+	// Exonic is true if the break point is in exon Number, otherwise it is in intron Number.
+	// Numbers are in transcript order, starting at 1.
+	Exonic bool
+	Number int
+	// CodingBefore is the number of coding bases of the transcript 5' of Pos.
+	CodingBefore int
+	// InCDS is true if the break point is between the first and last coding bases.
+	InCDS bool
+}
+
+// Feature gives the part of the transcript that was hit, as exon3 or intron2.
+func (h *Hit) Feature() string {
+	if h.Exonic {
+		return fmt.Sprintf("exon%d", h.Number)
+	}
+	return fmt.Sprintf("intron%d", h.Number)
+}
+
+// hit locates base pos in t. pos must be within t.
+func (t *Transcript) hit(pos int) *Hit {
+	h := &Hit{Transcript: t, Pos: pos}
+	n := len(t.Exons)
+	for i, e := range t.Exons {
+		// number in transcript order.
+		num := i + 1
+		if t.Strand == -1 {
+			num = n - i
+		}
+		if pos < e.Start {
+			// in the intron before exon i (on the chromosome).
+			h.Number = num
+			if t.Strand != -1 {
+				h.Number = num - 1
+			}
+			break
+		}
+		if pos < e.End {
+			h.Exonic, h.Number = true, num
+			break
+		}
+	}
+	if !t.Coding() {
+		return h
+	}
+	for _, c := range t.CDS {
+		if t.Strand == -1 {
+			if c.End > pos+1 {
+				h.CodingBefore += c.End - max(c.Start, pos+1)
+			}
+		} else if c.Start < pos {
+			h.CodingBefore += min(c.End, pos) - c.Start
+		}
+	}
+	h.InCDS = t.CDS[0].Start <= pos && pos < t.CDS[len(t.CDS)-1].End
+	return h
+}
+
+// codingAt reports whether the base at pos is in the CDS.
+func (t *Transcript) codingAt(pos int) bool {
+	for _, c := range t.CDS {
+		if c.Start <= pos && pos < c.End {
+			return true
+		}
+	}
+	return false
+}
+
+// Annotate gives a hit for each transcript that contains the 0-based position pos, with the
+// preferred transcript first: coding transcripts, then those with the longest CDS, then the
+// longest.
+func (a *Annotator) Annotate(chrom string, pos int) []*Hit {
+	ts := a.Transcripts(chrom, pos)
+	hits := make([]*Hit, len(ts))
+	for i, t := range ts {
+		hits[i] = t.hit(pos)
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.cdsLen() != b.cdsLen() {
+			return a.cdsLen() > b.cdsLen()
+		}
+		if a.End-a.Start != b.End-b.Start {
+			return a.End-a.Start > b.End-b.Start
+		}
+		return a.ID < b.ID
+	})
+	return hits
+}
+
+// Gene gives the name of the preferred gene at the 0-based position pos or "" if there is none.
+func (a *Annotator) Gene(chrom string, pos int) string {
+	if hits := a.Annotate(chrom, pos); len(hits) > 0 {
+		return hits[0].Gene
+	}
+	return ""
+}
+
+// FillGenes sets Gene1 and Gene2 of p from its (1-based) break points if they are empty.
+func (a *Annotator) FillGenes(p *db.SvBpPair) {
+	if p.Gene1 == "" {
+		p.Gene1 = a.Gene(p.Chr1, p.Bp1-1)
+	}
+	if p.Gene2 == "" {
+		p.Gene2 = a.Gene(p.Chr2, p.Bp2-1)
+	}
+}
+
+// Breakpoint is one end of a structural variant. The junction is just before the 0-based Pos.
+// Side is 1 if the sequence to the left of the junction is kept (the end is joined to the
+// other end after Pos-1) and -1 if the sequence from Pos on is kept. It is 0 if not known.
+type Breakpoint struct {
+	Chrom string
+	Pos   int
+	Side  int8
+}
+
+// base is the base next to the junction on the side that is kept.
+func (b Breakpoint) base() int {
+	if b.Side == 1 {
+		return b.Pos - 1
+	}
+	return b.Pos
+}
+
+// Frame is the predicted effect of a fusion on the reading frame.
+type Frame string
+
+const (
+	InFrame    Frame = "in-frame"
+	OutOfFrame Frame = "out-of-frame"
+	// NonCoding is used when a break point is outside the CDS of its transcript.
+	NonCoding Frame = "non-coding"
+	// NoFusion is used when the two ends don't join the 5' part of one transcript to the 3'
+	// part of another, or the sides of the break points aren't known.
+	NoFusion Frame = "none"
+)
+
+// Fusion is a pair of hits joined by a structural variant.
+type Fusion struct {
+	// End1 and End2 are the hits of the preferred transcripts at each end. Either is nil if the end
+	// is not in a transcript.
+	End1, End2 *Hit
+	// FivePrime is the end (1 or 2) that gives the 5' partner, or 0 if there is no fusion.
+	FivePrime int
+	Frame     Frame
+}
+
+// keptCoding is the number of coding bases kept when this is the 5' partner: those before Pos
+// and the base at Pos itself, which is on the kept side.
+func (h *Hit) keptCoding() int {
+	if h.codingAt(h.Pos) {
+		return h.CodingBefore + 1
+	}
+	return h.CodingBefore
+}
+
+// keepsFivePrime reports whether the kept side of b holds the 5' part of t.
+func keepsFivePrime(b Breakpoint, t *Transcript) bool {
+	return b.Side == t.Strand
+}
+
+// Fuse annotates both ends and picks the fusion of their preferred transcripts. If the kept
+// sides give a 5' and a 3' partner, the fusion is in-frame if the number of coding bases kept
+// from the 5' partner and the number dropped from the 3' partner are equal modulo 3. For
+// break points in introns these are the bases of the exons that are spliced together.
+func (a *Annotator) Fuse(b1, b2 Breakpoint) *Fusion {
+	h1, h2 := a.Annotate(b1.Chrom, b1.base()), a.Annotate(b2.Chrom, b2.base())
+	f := &Fusion{Frame: NoFusion}
+	if len(h1) > 0 {
+		f.End1 = h1[0]
+	}
+	if len(h2) > 0 {
+		f.End2 = h2[0]
+	}
+	if b1.Side == 0 || b2.Side == 0 {
+		return f
+	}
+	// use the preferred pair of transcripts that has a 5' and a 3' partner.
+	for _, x := range h1 {
+		for _, y := range h2 {
+			x5 := keepsFivePrime(b1, x.Transcript)
+			if x5 == keepsFivePrime(b2, y.Transcript) {
+				continue
+			}
+			f.End1, f.End2 = x, y
+			five, three := x, y
+			f.FivePrime = 1
+			if !x5 {
+				five, three = y, x
+				f.FivePrime = 2
+			}
+			switch {
+			case !five.InCDS || !three.InCDS:
+				f.Frame = NonCoding
+			case five.keptCoding()%3 == three.CodingBefore%3:
+				f.Frame = InFrame
+			default:
+				f.Frame = OutOfFrame
+			}
+			return f
+		}
+	}
+	return f
+}
@@ -0,0 +1,148 @@
+package annotate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Schaudge/ngsutils/db"
+)
+
+// gene A is on the + strand of chr1 and gene B on the - strand of chr2. Each has 3 exons.
+const testGTF = `chr1	t	exon	101	200	.	+	.	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr1	t	CDS	153	200	.	+	0	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr1	t	exon	301	400	.	+	.	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr1	t	CDS	301	400	.	+	0	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr1	t	exon	501	600	.	+	.	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr1	t	CDS	501	550	.	+	2	gene_id "GA"; transcript_id "TA"; gene_name "A";
+chr2	t	exon	1001	1100	.	-	.	gene_id "GB"; transcript_id "TB"; gene_name "B";
+chr2	t	CDS	1051	1100	.	-	0	gene_id "GB"; transcript_id "TB"; gene_name "B";
+chr2	t	exon	1201	1300	.	-	.	gene_id "GB"; transcript_id "TB"; gene_name "B";
+chr2	t	CDS	1201	1300	.	-	0	gene_id "GB"; transcript_id "TB"; gene_name "B";
+chr2	t	exon	1401	1500	.	-	.	gene_id "GB"; transcript_id "TB"; gene_name "B";
+chr2	t	CDS	1401	1460	.	-	0	gene_id "GB"; transcript_id "TB"; gene_name "B";
+`
+
+// testGFF3 has the same transcripts as testGTF.
+func testGFF3() string {
+	var b strings.Builder
+	b.WriteString("##gff-version 3\n")
+	b.WriteString("chr1\tt\tgene\t101\t600\t.\t+\t.\tID=gene:GA;Name=A\n")
+	b.WriteString("chr1\tt\tmRNA\t101\t600\t.\t+\t.\tID=transcript:TA;Parent=gene:GA\n")
+	b.WriteString("chr2\tt\tgene\t1001\t1500\t.\t-\t.\tID=gene:GB;Name=B\n")
+	b.WriteString("chr2\tt\tmRNA\t1001\t1500\t.\t-\t.\tID=transcript:TB;Parent=gene:GB\n")
+	for _, line := range strings.Split(strings.TrimSpace(testGTF), "\n") {
+		toks := strings.Split(line, "\t")
+		tx := "TA"
+		if toks[0] == "chr2" {
+			tx = "TB"
+		}
+		toks[8] = fmt.Sprintf("Parent=transcript:%s", tx)
+		b.WriteString(strings.Join(toks, "\t") + "\n")
+	}
+	return b.String()
+}
+
+func TestParseTranscripts(t *testing.T) {
+	for name, s := range map[string]string{"gtf": testGTF, "gff3": testGFF3()} {
+		ts, err := parseTranscripts(strings.NewReader(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ts) != 2 {
+			t.Fatalf("%s: expected 2 transcripts, got %d", name, len(ts))
+		}
+		a := ts[0]
+		if a.Gene != "A" || a.Strand != 1 || a.Start != 100 || a.End != 600 || len(a.Exons) != 3 || a.cdsLen() != 48+100+50 {
+			t.Errorf("%s: bad transcript %+v", name, a)
+		}
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+	ts, err := parseTranscripts(strings.NewReader(testGTF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(ts)
+	cases := []struct {
+		chrom   string
+		pos     int
+		gene    string
+		feature string
+	}{
+		{"chr1", 150, "A", "exon1"},
+		{"1", 249, "A", "intron1"},
+		{"chr1", 599, "A", "exon3"},
+		{"chr2", 1450, "B", "exon1"},
+		{"chr2", 1349, "B", "intron1"},
+		{"chr2", 1149, "B", "intron2"},
+		{"chr2", 1000, "B", "exon3"},
+	}
+	for _, c := range cases {
+		hits := a.Annotate(c.chrom, c.pos)
+		if len(hits) != 1 || hits[0].Gene != c.gene || hits[0].Feature() != c.feature {
+			t.Errorf("%s:%d: expected %s %s, got %d hits", c.chrom, c.pos, c.gene, c.feature, len(hits))
+			for _, h := range hits {
+				t.Errorf("  %s %s", h.Gene, h.Feature())
+			}
+		}
+	}
+	if hits := a.Annotate("chr1", 600); len(hits) != 0 {
+		t.Errorf("expected no hits after the end of A, got %d", len(hits))
+	}
+
+	p := db.SvBpPair{Chr1: "1", Bp1: 250, Chr2: "2", Bp2: 1350}
+	a.FillGenes(&p)
+	if p.Gene1 != "A" || p.Gene2 != "B" {
+		t.Errorf("expected genes A and B, got %s and %s", p.Gene1, p.Gene2)
+	}
+}
+
+func TestFuse(t *testing.T) {
+	ts, err := parseTranscripts(strings.NewReader(testGTF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(ts)
+	// the start of A up to intron 1 keeps 48 coding bases. the end of B from its intron 1 drops
+	// 60 and from its intron 2 drops 160.
+	cases := []struct {
+		b1, b2 Breakpoint
+		five   int
+		frame  Frame
+	}{
+		{Breakpoint{"chr1", 250, 1}, Breakpoint{"chr2", 1350, 1}, 1, InFrame},
+		{Breakpoint{"chr2", 1350, 1}, Breakpoint{"chr1", 250, 1}, 2, InFrame},
+		{Breakpoint{"chr1", 250, 1}, Breakpoint{"chr2", 1150, 1}, 1, OutOfFrame},
+		// both keep the 5' end of their gene.
+		{Breakpoint{"chr1", 250, 1}, Breakpoint{"chr2", 1350, -1}, 0, NoFusion},
+		{Breakpoint{"chr1", 250, 0}, Breakpoint{"chr2", 1350, 1}, 0, NoFusion},
+		// A is kept from the 3' UTR.
+		{Breakpoint{"chr1", 575, -1}, Breakpoint{"chr2", 1350, -1}, 2, NonCoding},
+	}
+	for i, c := range cases {
+		f := a.Fuse(c.b1, c.b2)
+		if f.FivePrime != c.five || f.Frame != c.frame {
+			t.Errorf("case %d: expected %d %s, got %d %s", i, c.five, c.frame, f.FivePrime, f.Frame)
+		}
+	}
+}
+
+func TestParseBedPE(t *testing.T) {
+	b1, b2, err := ParseBedPE("1\t100\t250\t1\t2\t1350\t1450\t-1\t0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b1 != (Breakpoint{"1", 250, 1}) || b2 != (Breakpoint{"2", 1350, -1}) {
+		t.Errorf("got %+v %+v", b1, b2)
+	}
+	b1, _, err = ParseBedPE("1\t100\t250\t1\t2\t1350\t1450\t-1\t2")
+	if err != nil || b1.Side != 0 {
+		t.Errorf("expected splitters to have unknown sides, got %+v (%v)", b1, err)
+	}
+	b1, b2, err = ParseBedPE("1\t100\t250\t2\t1350\t1450\tx\t0\t-\t+")
+	if err != nil || b1 != (Breakpoint{"1", 100, -1}) || b2 != (Breakpoint{"2", 1450, 1}) {
+		t.Errorf("got %+v %+v (%v)", b1, b2, err)
+	}
+}
@@ -0,0 +1,265 @@
+package annotate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/utils"
+)
+
+// Transcript is a transcript from a GTF or GFF3. Coordinates are 0-based and half-open.
+type Transcript struct {
+	ID     string
+	GeneID string
+	Gene   string
+	Chrom  string
+	Strand int8
+	Start  int
+	End    int
+	// Exons and CDS are sorted by position on the chromosome, not in transcript order.
+	Exons []Span
+	CDS   []Span
+}
+
+// Span is a 0-based, half-open interval.
+type Span struct {
+	Start, End int
+}
+
+// Coding reports whether the transcript has a CDS.
+func (t *Transcript) Coding() bool {
+	return len(t.CDS) > 0
+}
+
+// cdsLen is the number of coding bases in the transcript.
+func (t *Transcript) cdsLen() int {
+	n := 0
+	for _, c := range t.CDS {
+		n += c.End - c.Start
+	}
+	return n
+}
+
+// ReadTranscripts reads the transcripts from a (possibly gzipped) GTF or GFF3 file. The format is
+// decided from the attributes of the first feature.
+func ReadTranscripts(path string) ([]*Transcript, error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	ts, err := parseTranscripts(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("annotate: error reading %s: %w", path, err)
+	}
+	return ts, nil
+}
+
+// feature is a line of a GTF or GFF3.
+type feature struct {
+	chrom  string
+	typ    string
+	start  int
+	end    int
+	strand int8
+	attrs  map[string]string
+}
+
+// parseAttributes parses the ninth column of a GTF (key "value"; ...) or GFF3 (key=value;...).
+func parseAttributes(s string, gff3 bool) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range strings.Split(s, ";") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		if gff3 {
+			i := strings.IndexByte(kv, '=')
+			if i < 0 {
+				continue
+			}
+			v, err := url.PathUnescape(kv[i+1:])
+			if err != nil {
+				v = kv[i+1:]
+			}
+			attrs[kv[:i]] = v
+			continue
+		}
+		i := strings.IndexByte(kv, ' ')
+		if i < 0 {
+			continue
+		}
+		k, v := kv[:i], strings.Trim(strings.TrimSpace(kv[i+1:]), `"`)
+		// GTF allows repeated keys such as tag; the first is kept.
+		if _, ok := attrs[k]; !ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
+
+func parseFeature(line string, gff3 bool) (*feature, error) {
+	toks := strings.Split(line, "\t")
+	if len(toks) < 9 {
+		return nil, fmt.Errorf("expected 9 columns in %q", line)
+	}
+	start, err := strconv.Atoi(toks[3])
+	if err != nil {
+		return nil, err
+	}
+	end, err := strconv.Atoi(toks[4])
+	if err != nil {
+		return nil, err
+	}
+	f := &feature{chrom: toks[0], typ: toks[2], start: start - 1, end: end, attrs: parseAttributes(toks[8], gff3)}
+	switch toks[6] {
+	case "+":
+		f.strand = 1
+	case "-":
+		f.strand = -1
+	}
+	return f, nil
+}
+
+func parseTranscripts(r io.Reader) ([]*Transcript, error) {
+	br := bufio.NewReader(r)
+	byID := make(map[string]*Transcript)
+	var order []string
+	// for GFF3, gene names are on the gene features, which transcripts refer to by Parent.
+	geneNames := make(map[string]string)
+	gff3, decided := false, false
+	transcript := func(id string, f *feature) *Transcript {
+		t, ok := byID[id]
+		if !ok {
+			t = &Transcript{ID: id, Chrom: f.chrom, Strand: f.strand, Start: f.start, End: f.end}
+			byID[id] = t
+			order = append(order, id)
+		}
+		return t
+	}
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' {
+			if !decided {
+				toks := strings.Split(line, "\t")
+				gff3 = len(toks) >= 9 && !strings.Contains(toks[8], `"`) && strings.Contains(toks[8], "=")
+				decided = true
+			}
+			f, perr := parseFeature(line, gff3)
+			if perr != nil {
+				return nil, perr
+			}
+			if gff3 {
+				addGFF3Feature(f, transcript, geneNames)
+			} else {
+				addGTFFeature(f, transcript)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	ts := make([]*Transcript, 0, len(order))
+	for _, id := range order {
+		t := byID[id]
+		if len(t.Exons) == 0 {
+			continue
+		}
+		if t.Gene == "" {
+			t.Gene = geneNames[t.GeneID]
+		}
+		if t.Gene == "" {
+			t.Gene = t.GeneID
+		}
+		for _, ss := range [][]Span{t.Exons, t.CDS} {
+			sort.Slice(ss, func(i, j int) bool { return ss[i].Start < ss[j].Start })
+		}
+		t.Start, t.End = min(t.Start, t.Exons[0].Start), max(t.End, t.Exons[len(t.Exons)-1].End)
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+func (t *Transcript) extend(f *feature) {
+	t.Start, t.End = min(t.Start, f.start), max(t.End, f.end)
+}
+
+func addGTFFeature(f *feature, transcript func(string, *feature) *Transcript) {
+	id := f.attrs["transcript_id"]
+	if id == "" {
+		return
+	}
+	t := transcript(id, f)
+	if t.GeneID == "" {
+		t.GeneID = f.attrs["gene_id"]
+	}
+	if t.Gene == "" {
+		t.Gene = f.attrs["gene_name"]
+	}
+	switch f.typ {
+	case "exon":
+		t.Exons = append(t.Exons, Span{f.start, f.end})
+	case "CDS":
+		t.CDS = append(t.CDS, Span{f.start, f.end})
+	case "stop_codon":
+		// GTF leaves the stop codon out of the CDS.
+		t.CDS = append(t.CDS, Span{f.start, f.end})
+	}
+	t.extend(f)
+}
+
+func addGFF3Feature(f *feature, transcript func(string, *feature) *Transcript, geneNames map[string]string) {
+	switch f.typ {
+	case "gene", "ncRNA_gene", "pseudogene":
+		name := f.attrs["Name"]
+		if name == "" {
+			name = f.attrs["gene_name"]
+		}
+		geneNames[f.attrs["ID"]] = name
+	case "exon", "CDS":
+		for _, p := range strings.Split(f.attrs["Parent"], ",") {
+			t := transcript(p, f)
+			if f.typ == "exon" {
+				t.Exons = append(t.Exons, Span{f.start, f.end})
+			} else {
+				t.CDS = append(t.CDS, Span{f.start, f.end})
+			}
+			t.extend(f)
+		}
+	default:
+		// any feature that is the parent of exons is a transcript (mRNA, lnc_RNA, transcript, ...).
+		id := f.attrs["ID"]
+		if id == "" || f.attrs["Parent"] == "" {
+			return
+		}
+		t := transcript(id, f)
+		t.GeneID = strings.Split(f.attrs["Parent"], ",")[0]
+		if n := f.attrs["gene_name"]; n != "" {
+			t.Gene = n
+		}
+		t.extend(f)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,188 @@
+package annotate
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/db"
+	"github.com/Schaudge/ngsutils/utils"
+	arg "github.com/alexflint/go-arg"
+)
+
+// ParseBedPE gives the break points of a line of excord output or of a BEDPE with strands in
+// columns 9 and 10. For excord, discordant and soft-clip consensus records have the side of each
+// end in the strand column. Splitters have the strand of the alignment there, so their sides are
+// left unknown.
+func ParseBedPE(line string) (b1, b2 Breakpoint, err error) {
+	toks := strings.Split(line, "\t")
+	if len(toks) < 6 {
+		return b1, b2, fmt.Errorf("annotate: expected at least 6 columns in %q", line)
+	}
+	if len(toks) >= 10 && isStrand(toks[8]) && isStrand(toks[9]) {
+		if b1, err = breakpoint(toks[0], toks[1], toks[2], sideOf(toks[8])); err != nil {
+			return
+		}
+		b2, err = breakpoint(toks[3], toks[4], toks[5], sideOf(toks[9]))
+		return
+	}
+	if len(toks) < 9 {
+		return b1, b2, fmt.Errorf("annotate: expected 9 columns of excord output in %q", line)
+	}
+	typ, err := strconv.Atoi(toks[8])
+	if err != nil {
+		return
+	}
+	s1, s2 := toks[3], toks[7]
+	if typ > 0 {
+		s1, s2 = "0", "0"
+	}
+	if b1, err = breakpoint(toks[0], toks[1], toks[2], sideOf(s1)); err != nil {
+		return
+	}
+	b2, err = breakpoint(toks[4], toks[5], toks[6], sideOf(s2))
+	return
+}
+
+func isStrand(s string) bool {
+	return s == "+" || s == "-" || s == "."
+}
+
+func sideOf(s string) int8 {
+	switch s {
+	case "+", "1":
+		return 1
+	case "-", "-1":
+		return -1
+	}
+	return 0
+}
+
+// breakpoint places the junction at the end of the interval if the left side is kept, at the
+// start if the right side is kept and in the middle if the side is not known.
+func breakpoint(chrom, start, end string, side int8) (Breakpoint, error) {
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return Breakpoint{}, err
+	}
+	e, err := strconv.Atoi(end)
+	if err != nil {
+		return Breakpoint{}, err
+	}
+	b := Breakpoint{Chrom: chrom, Side: side}
+	switch side {
+	case 1:
+		b.Pos = e
+	case -1:
+		b.Pos = s
+	default:
+		b.Pos = (s + e) / 2
+	}
+	return b, nil
+}
+
+// SvBpPairs reads the break points from a BEDPE or excord output and fills in their genes.
+// Records with an end that isn't in a gene are skipped.
+func (a *Annotator) SvBpPairs(path string) ([]db.SvBpPair, error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	var pairs []db.SvBpPair
+	err = eachLine(rdr.Reader, func(line string) error {
+		b1, b2, err := ParseBedPE(line)
+		if err != nil {
+			return err
+		}
+		p := db.SvBpPair{Chr1: b1.Chrom, Bp1: b1.base() + 1, Chr2: b2.Chrom, Bp2: b2.base() + 1}
+		a.FillGenes(&p)
+		if p.Gene1 != "" && p.Gene2 != "" {
+			pairs = append(pairs, p)
+		}
+		return nil
+	})
+	return pairs, err
+}
+
+func eachLine(r *bufio.Reader, fn func(string) error) error {
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			if ferr := fn(line); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// columns gives the annotation of a fusion as gene, transcript and feature for each end, the end
+// that is the 5' partner and the frame.
+func (f *Fusion) columns() string {
+	cols := make([]string, 0, 8)
+	for _, h := range []*Hit{f.End1, f.End2} {
+		if h == nil {
+			cols = append(cols, ".", ".", ".")
+			continue
+		}
+		cols = append(cols, h.Gene, h.ID, h.Feature())
+	}
+	five := "."
+	if f.FivePrime != 0 {
+		five = strconv.Itoa(f.FivePrime)
+	}
+	return strings.Join(append(cols, five, string(f.Frame)), "\t")
+}
+
+type cliarg struct {
+	Annotation string `arg:"-g,required,help:GTF or GFF3 of transcripts"`
+	BedPE      string `arg:"positional,help:excord output or BEDPE with strands in columns 9 and 10. reads stdin if not given"`
+}
+
+// Main is the entry-point for the annotate sub-command. It adds the gene, transcript and exon or
+// intron of each end and the predicted frame of the fusion to each line.
+func Main() {
+	cli := &cliarg{BedPE: "-"}
+	arg.MustParse(cli)
+	a, err := Load(cli.Annotation)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var rdr *bufio.Reader
+	if cli.BedPE == "-" {
+		rdr = bufio.NewReader(os.Stdin)
+	} else {
+		f, err := utils.Ropen(cli.BedPE)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rdr = f.Reader
+	}
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(w, "##annotate=gene1\ttranscript1\tfeature1\tgene2\ttranscript2\tfeature2\tfive_prime\tframe")
+	err = eachLine(rdr, func(line string) error {
+		b1, b2, err := ParseBedPE(line)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\t%s\n", line, a.Fuse(b1, b2).columns())
+		return err
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
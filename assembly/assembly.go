@@ -0,0 +1,161 @@
+// Package assembly assembles reads from around a structural-variant breakpoint into contigs and
+// aligns the contigs back to the reference on either side of the breakpoint to find the exact
+// junction.
+//
+// The assembler is a greedy walk of a de Bruijn graph. It is meant for the few hundred reads
+// around a single event, not for whole genomes.
+package assembly
+
+import (
+	"sort"
+)
+
+// Options control the assembly.
+type Options struct {
+	// K is the k-mer size of the graph.
+	K int
+	// MinKmerCount is the number of times a k-mer must be seen (on either strand) to be used.
+	// It removes most sequencing errors.
+	MinKmerCount int
+	// MinContigLen is the length of the shortest contig that is reported.
+	MinContigLen int
+}
+
+// DefaultOptions work for short reads of 100 bases or more.
+var DefaultOptions = Options{K: 25, MinKmerCount: 2, MinContigLen: 60}
+
+// maxContigLen stops the walk in repeats that the used k-mers don't catch.
+const maxContigLen = 10000
+
+// Assemble returns the contigs from reads. Reads may come from either strand; each contig is
+// reported once, in the orientation in which it was walked.
+func Assemble(reads [][]byte, o Options) []string {
+	counts := CountKmers(reads, o.K)
+	type seed struct {
+		kmer  string
+		count int
+	}
+	var seeds []seed
+	for km, n := range counts {
+		if n >= o.MinKmerCount {
+			seeds = append(seeds, seed{km, n})
+		} else {
+			delete(counts, km)
+		}
+	}
+	// most abundant first, with ties broken by sequence so the output doesn't depend on map order.
+	sort.Slice(seeds, func(i, j int) bool {
+		if seeds[i].count != seeds[j].count {
+			return seeds[i].count > seeds[j].count
+		}
+		return seeds[i].kmer < seeds[j].kmer
+	})
+
+	used := make(map[string]bool, len(counts))
+	use := func(km string) {
+		used[km] = true
+		used[revComp(km)] = true
+	}
+	// next gives the most abundant unused k-mer that extends km by one base to the right (or left).
+	next := func(km string, right bool) string {
+		best, bestN := "", 0
+		for _, b := range "ACGT" {
+			var cand string
+			if right {
+				cand = km[1:] + string(b)
+			} else {
+				cand = string(b) + km[:len(km)-1]
+			}
+			if n := counts[cand]; n > bestN && !used[cand] {
+				best, bestN = cand, n
+			}
+		}
+		return best
+	}
+
+	var contigs []string
+	for _, s := range seeds {
+		if used[s.kmer] {
+			continue
+		}
+		use(s.kmer)
+		var left, right []byte
+		for km := s.kmer; len(right) < maxContigLen; {
+			if km = next(km, true); km == "" {
+				break
+			}
+			use(km)
+			right = append(right, km[len(km)-1])
+		}
+		for km := s.kmer; len(left) < maxContigLen; {
+			if km = next(km, false); km == "" {
+				break
+			}
+			use(km)
+			left = append(left, km[0])
+		}
+		for i, j := 0, len(left)-1; i < j; i, j = i+1, j-1 {
+			left[i], left[j] = left[j], left[i]
+		}
+		if contig := string(left) + s.kmer + string(right); len(contig) >= o.MinContigLen {
+			contigs = append(contigs, contig)
+		}
+	}
+	return contigs
+}
+
+// CountKmers counts the k-mers of reads and of their reverse complements. K-mers containing
+// anything but A, C, G or T are skipped.
+func CountKmers(reads [][]byte, k int) map[string]int {
+	counts := make(map[string]int)
+	for _, r := range reads {
+		for _, s := range []string{upper(r), revComp(upper(r))} {
+			for i := 0; i+k <= len(s); i++ {
+				if km := s[i : i+k]; isACGT(km) {
+					counts[km]++
+				}
+			}
+		}
+	}
+	return counts
+}
+
+func upper(s []byte) string {
+	b := make([]byte, len(s))
+	for i, c := range s {
+		b[i] = c &^ 0x20
+	}
+	return string(b)
+}
+
+func isACGT(s string) bool {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case 'A', 'C', 'G', 'T':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func revComp(s string) string {
+	b := make([]byte, len(s))
+	for i := 0; i < len(s); i++ {
+		var c byte
+		switch s[i] &^ 0x20 {
+		case 'A':
+			c = 'T'
+		case 'C':
+			c = 'G'
+		case 'G':
+			c = 'C'
+		case 'T':
+			c = 'A'
+		default:
+			c = 'N'
+		}
+		b[len(s)-1-i] = c
+	}
+	return string(b)
+}
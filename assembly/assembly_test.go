@@ -0,0 +1,89 @@
+package assembly
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func randomSeq(r *rand.Rand, n int) []byte {
+	s := make([]byte, n)
+	for i := range s {
+		s[i] = "ACGT"[r.Intn(4)]
+	}
+	return s
+}
+
+// tile gives reads of length n every step bases along s, alternating strands.
+func tile(s string, n, step int) [][]byte {
+	var reads [][]byte
+	for i := 0; i+n <= len(s); i += step {
+		r := s[i : i+n]
+		if (i/step)%2 == 1 {
+			r = revComp(r)
+		}
+		reads = append(reads, []byte(r))
+	}
+	return reads
+}
+
+func TestJunctions(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	a, b := randomSeq(r, 1000), randomSeq(r, 1000)
+	// make sure the sides of the junction differ so that there's no microhomology by chance.
+	b[600] = "CGTA"[baseIdx(a[500])]
+	a[499] = "CGTA"[baseIdx(b[599])]
+	windows := []Window{{"A", 250, string(a[250:750])}, {"B", 350, string(b[350:850])}}
+
+	cases := []struct {
+		name string
+		ins  string
+	}{
+		{"fusion", ""},
+		// the inserted sequence differs from the reference on both sides.
+		{"insertion", string("CGTA"[baseIdx(a[500])]) + "TAGGCAT" + string("CGTA"[baseIdx(b[599])])},
+	}
+	for _, c := range cases {
+		fused := string(a[300:500]) + c.ins + string(b[600:800])
+		js := Junctions(tile(fused, 100, 4), windows, DefaultOptions)
+		if len(js) != 1 {
+			t.Fatalf("%s: expected 1 junction, got %d", c.name, len(js))
+		}
+		j := js[0]
+		// the contig may have been walked on either strand.
+		if j.Chrom1 == "B" {
+			if j.Chrom2 != "A" || j.Pos1 != 601 || j.Strand1 != -1 || j.Pos2 != 500 || j.Strand2 != -1 || j.Insertion != revComp(c.ins) {
+				t.Errorf("%s: got %s", c.name, j)
+			}
+		} else if j.Chrom2 != "B" || j.Pos1 != 500 || j.Strand1 != 1 || j.Pos2 != 601 || j.Strand2 != 1 || j.Insertion != c.ins {
+			t.Errorf("%s: got %s", c.name, j)
+		}
+		if j.Homology != "" {
+			t.Errorf("%s: unexpected homology %s", c.name, j.Homology)
+		}
+		// reads start every 4 bases and need 8 bases on each side of the junction.
+		if want := (100 - 16 - len(c.ins)) / 4; j.Support < want-1 || j.Support > want+1 {
+			t.Errorf("%s: expected support of about %d, got %d", c.name, want, j.Support)
+		}
+	}
+}
+
+func TestJunctionsNone(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	a := randomSeq(r, 1000)
+	windows := []Window{{"A", 0, string(a)}}
+	if js := Junctions(tile(string(a[200:600]), 100, 4), windows, DefaultOptions); len(js) != 0 {
+		t.Fatalf("expected no junctions in reference reads, got %s", js[0])
+	}
+}
+
+func baseIdx(b byte) int {
+	switch b {
+	case 'A':
+		return 0
+	case 'C':
+		return 1
+	case 'G':
+		return 2
+	}
+	return 3
+}
@@ -0,0 +1,267 @@
+package assembly
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// seedK is the k-mer size used to place contigs on the reference windows.
+	seedK = 15
+	// minFlank is the number of contig bases that must align on each side of a junction.
+	minFlank = 20
+	// maxShift is the longest microhomology or inserted sequence allowed at a junction.
+	maxShift = 50
+	// supportFlank is the number of bases on each side of a junction that a read must match
+	// to support it.
+	supportFlank = 8
+	// mismatchPenalty is subtracted from the alignment score for a mismatch. A match adds 1.
+	mismatchPenalty = 3
+)
+
+// Window is a piece of the reference sequence. Start is the 0-based position of the first base
+// of Seq on Chrom.
+type Window struct {
+	Chrom string
+	Start int
+	Seq   string
+}
+
+// Junction is a contig that aligns to the reference in two parts.
+type Junction struct {
+	Contig string
+	// LeftEnd is the end of the part of the contig aligned before the junction and RightStart is
+	// the start of the part aligned after it. If RightStart < LeftEnd the bases between them are
+	// microhomology, if RightStart > LeftEnd they are inserted.
+	LeftEnd, RightStart int
+
+	// Pos1 is the last base (1-based) aligned before the junction and Pos2 the first aligned after
+	// it. A strand is -1 if the contig aligns to the reverse complement of the reference there.
+	Chrom1  string
+	Pos1    int
+	Strand1 int8
+	Chrom2  string
+	Pos2    int
+	Strand2 int8
+
+	Homology  string
+	Insertion string
+	// Support is the number of reads that span the junction.
+	Support int
+}
+
+// Sequence gives the junction with up to flank bases of the contig on each side. The microhomology
+// or inserted bases are between the brackets.
+func (j *Junction) Sequence(flank int) string {
+	lo, hi := j.LeftEnd, j.RightStart
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+	return fmt.Sprintf("%s[%s]%s", j.Contig[max(0, lo-flank):lo], strings.ToLower(j.Contig[lo:hi]), j.Contig[hi:min(len(j.Contig), hi+flank)])
+}
+
+// String gives the junction in the same columns as the header from JunctionHeader.
+func (j *Junction) String() string {
+	return fmt.Sprintf("%s\t%d\t%d\t%s\t%d\t%d\t%s\t%s\t%d\t%s", j.Chrom1, j.Pos1, j.Strand1, j.Chrom2, j.Pos2, j.Strand2,
+		orDot(j.Homology), orDot(j.Insertion), j.Support, j.Sequence(30))
+}
+
+// JunctionHeader is the header for the output of Junction.String.
+const JunctionHeader = "#chrom1\tpos1\tstrand1\tchrom2\tpos2\tstrand2\thomology\tinsertion\tsupport\tjunction"
+
+func orDot(s string) string {
+	if s == "" {
+		return "."
+	}
+	return s
+}
+
+// segment is an ungapped alignment of contig[a:e] to window w (reverse-complemented if not forward)
+// starting at a+d.
+type segment struct {
+	w       *Window
+	forward bool
+	a, e, d int
+	score   int
+}
+
+// refPos gives the 1-based reference position of contig base i in the segment.
+func (s *segment) refPos(i int) int {
+	if s.forward {
+		return s.w.Start + i + s.d + 1
+	}
+	return s.w.Start + len(s.w.Seq) - (i + s.d)
+}
+
+func strandOf(forward bool) int8 {
+	if forward {
+		return 1
+	}
+	return -1
+}
+
+// segments gives the best-scoring ungapped alignment of the contig on each diagonal that shares
+// a seed with the window, on both strands.
+func segments(contig string, w *Window) []segment {
+	var segs []segment
+	for _, forward := range []bool{true, false} {
+		ref := strings.ToUpper(w.Seq)
+		if !forward {
+			ref = revComp(ref)
+		}
+		index := make(map[string][]int)
+		for i := 0; i+seedK <= len(ref); i++ {
+			index[ref[i:i+seedK]] = append(index[ref[i:i+seedK]], i)
+		}
+		seen := make(map[int]bool)
+		for i := 0; i+seedK <= len(contig); i++ {
+			for _, p := range index[contig[i:i+seedK]] {
+				d := p - i
+				if seen[d] {
+					continue
+				}
+				seen[d] = true
+				if s, ok := bestSegment(contig, ref, d); ok {
+					s.w, s.forward = w, forward
+					segs = append(segs, s)
+				}
+			}
+		}
+	}
+	return segs
+}
+
+// bestSegment finds the highest-scoring run of contig aligned to ref on diagonal d.
+func bestSegment(contig, ref string, d int) (segment, bool) {
+	best := segment{d: d}
+	score, start := 0, max(0, -d)
+	for i := start; i < len(contig) && i+d < len(ref); i++ {
+		switch c, r := contig[i], ref[i+d]; {
+		case c == 'N' || r == 'N':
+		case c == r:
+			score++
+		default:
+			score -= mismatchPenalty
+		}
+		if score <= 0 {
+			score, start = 0, i+1
+			continue
+		}
+		if score > best.score {
+			best.a, best.e, best.score = start, i+1, score
+		}
+	}
+	return best, best.e-best.a >= minFlank
+}
+
+// AlignJunction aligns the contig to the windows and returns the best junction between two
+// segments, or nil if the contig doesn't align in two parts.
+func AlignJunction(contig string, windows []Window) *Junction {
+	var segs []segment
+	for i := range windows {
+		segs = append(segs, segments(contig, &windows[i])...)
+	}
+	var best *Junction
+	bestScore := 0
+	for _, l := range segs {
+		for _, r := range segs {
+			if l.a >= r.a || l.e >= r.e || iabs(r.a-l.e) > maxShift {
+				continue
+			}
+			// the same alignment can't be both sides of a junction.
+			if l.w == r.w && l.forward == r.forward && l.d == r.d {
+				continue
+			}
+			score := l.score + r.score
+			if r.a < l.e {
+				// don't count the microhomology twice.
+				score -= l.e - r.a
+			}
+			if score <= bestScore {
+				continue
+			}
+			bestScore = score
+			best = &Junction{Contig: contig, LeftEnd: l.e, RightStart: r.a,
+				Chrom1: l.w.Chrom, Pos1: l.refPos(l.e - 1), Strand1: strandOf(l.forward),
+				Chrom2: r.w.Chrom, Pos2: r.refPos(r.a), Strand2: strandOf(r.forward)}
+			if r.a < l.e {
+				best.Homology = contig[r.a:l.e]
+			} else if r.a > l.e {
+				best.Insertion = contig[l.e:r.a]
+			}
+		}
+	}
+	return best
+}
+
+// CountSupport sets Support to the number of reads that contain the junction with supportFlank
+// bases on each side, on either strand.
+func (j *Junction) CountSupport(reads [][]byte) {
+	lo, hi := j.LeftEnd, j.RightStart
+	if hi < lo {
+		lo, hi = hi, lo
+	}
+	span := j.Contig[max(0, lo-supportFlank):min(len(j.Contig), hi+supportFlank)]
+	rc := revComp(span)
+	j.Support = 0
+	for _, r := range reads {
+		if s := upper(r); strings.Contains(s, span) || strings.Contains(s, rc) {
+			j.Support++
+		}
+	}
+}
+
+// Junctions assembles the reads, aligns the contigs to the windows and returns the junctions
+// with the most supported first. When several contigs give the same breakpoints, the one with
+// the most support is kept.
+func Junctions(reads [][]byte, windows []Window, o Options) []*Junction {
+	byBreak := make(map[string]*Junction)
+	for _, c := range Assemble(reads, o) {
+		j := AlignJunction(c, windows)
+		if j == nil {
+			continue
+		}
+		j.CountSupport(reads)
+		// a contig may be assembled on either strand, which swaps and flips the ends of its junction.
+		key := fmt.Sprintf("%s:%d:%d-%s:%d:%d", j.Chrom1, j.Pos1, j.Strand1, j.Chrom2, j.Pos2, j.Strand2)
+		if rkey := fmt.Sprintf("%s:%d:%d-%s:%d:%d", j.Chrom2, j.Pos2, -j.Strand2, j.Chrom1, j.Pos1, -j.Strand1); rkey < key {
+			key = rkey
+		}
+		if prev, ok := byBreak[key]; !ok || j.Support > prev.Support || j.Support == prev.Support && len(j.Contig) > len(prev.Contig) {
+			byBreak[key] = j
+		}
+	}
+	js := make([]*Junction, 0, len(byBreak))
+	for _, j := range byBreak {
+		js = append(js, j)
+	}
+	sort.Slice(js, func(a, b int) bool {
+		if js[a].Support != js[b].Support {
+			return js[a].Support > js[b].Support
+		}
+		return js[a].String() < js[b].String()
+	})
+	return js
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func iabs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
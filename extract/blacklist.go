@@ -0,0 +1,56 @@
+package extract
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/Schaudge/ngsutils/intervals"
+)
+
+// blacklist holds regions such as centromeres and satellite repeats in which alignments are not
+// trusted.
+type blacklist struct {
+	// set is keyed by chromosome without a "chr" prefix.
+	set *intervals.SetIndex
+	// flag keeps records that overlap the blacklist but marks them in an extra column.
+	flag bool
+
+	excludedDiscordant int
+	excludedSplitter   int
+	excludedJunction   int
+}
+
+// readBlacklist reads the intervals in the first 3 columns of a (possibly gzipped) BED.
+func readBlacklist(path string, flag bool) (*blacklist, error) {
+	set, err := intervals.LoadBEDSet(path, sstripChr)
+	if err != nil {
+		return nil, fmt.Errorf("excord: reading blacklist: %w", err)
+	}
+	return &blacklist{set: set, flag: flag}, nil
+}
+
+// overlaps reports whether [s, e) on chrom overlaps a blacklisted interval.
+func (bl *blacklist) overlaps(chrom string, s, e int) bool {
+	return bl.set.Overlaps(chrom, s, e)
+}
+
+// endsIn gives the ends of b that overlap the blacklist: 1 for the first, 2 for the second and
+// 3 for both.
+func (bl *blacklist) endsIn(b *bedPE) int8 {
+	var ends int8
+	if bl.overlaps(b.c1, b.s1, b.e1) {
+		ends |= 1
+	}
+	if b.c2 != "-1" && bl.overlaps(b.c2, b.s2, b.e2) {
+		ends |= 2
+	}
+	return ends
+}
+
+func (bl *blacklist) log() {
+	verb := "excluded"
+	if bl.flag {
+		verb = "flagged"
+	}
+	log.Printf("%s %d discordant, %d splitter and %d soft-clip junction records overlapping the blacklist", verb, bl.excludedDiscordant, bl.excludedSplitter, bl.excludedJunction)
+}
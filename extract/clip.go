@@ -0,0 +1,422 @@
+package extract
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/faidx"
+)
+
+const (
+	// minClipLen is the shortest soft-clip that is piled up.
+	minClipLen = 10
+	// minClipSupport is the number of reads needed for a clip position to be used.
+	minClipSupport = 3
+	// clipAnchorLen is how many aligned bases next to the clip are kept for the consensus.
+	clipAnchorLen = 30
+	// minConsensusDepth is the number of reads that must cover a base of the consensus.
+	minConsensusDepth = 2
+	// maxJunctionShift is the longest microhomology or inserted sequence that is reported.
+	maxJunctionShift = 50
+	// junctionK is the length of the k-mers used to find clusters that join. The clipped sequence of
+	// one cluster must contain the first k-mer of the aligned sequence of the other so it can't be
+	// longer than minClipLen.
+	junctionK = minClipLen
+	// clipFlushEvery is how far the stream advances between moving finished clusters out of the pile.
+	clipFlushEvery = 1000
+)
+
+// clipCluster is the set of reads that are soft-clipped at the same position and on the same side.
+// side is 1 if the alignments end at pos and the clipped sequence follows it, or -1 if the alignments
+// start at pos and the clipped sequence precedes it. This matches the strand convention for
+// discordant pairs: the breakpoint is to the right of a 1 and to the left of a -1.
+type clipCluster struct {
+	ref   int
+	chrom string
+	pos   int
+	side  int8
+
+	// clips and anchors are the read sequences until the consensus is made.
+	clips   [][]byte
+	anchors [][]byte
+
+	support int
+	clip    []byte
+	anchor  []byte
+}
+
+type clipKey struct {
+	ref  int
+	pos  int
+	side int8
+}
+
+// clipPile collects soft-clipped reads from a sorted stream and builds a consensus of the clipped
+// and aligned sequence for each position with enough support.
+type clipPile struct {
+	open      map[clipKey]*clipCluster
+	done      []*clipCluster
+	ref       int
+	lastFlush int
+}
+
+func newClipPile() *clipPile {
+	return &clipPile{open: make(map[clipKey]*clipCluster), ref: -1}
+}
+
+// softClip gives the length of the soft-clip at the end (or start) of the cigar, ignoring hard-clips.
+func softClip(cig sam.Cigar, atEnd bool) int {
+	i, step := 0, 1
+	if atEnd {
+		i, step = len(cig)-1, -1
+	}
+	for i >= 0 && i < len(cig) && cig[i].Type() == sam.CigarHardClipped {
+		i += step
+	}
+	if i < 0 || i >= len(cig) || cig[i].Type() != sam.CigarSoftClipped {
+		return 0
+	}
+	return cig[i].Len()
+}
+
+// add piles up r if it is soft-clipped. Records must be sorted by position. With a fasta, the clip
+// position is refined as in recEnd and recStart and the mismatched bases become part of the clip.
+func (p *clipPile) add(r *sam.Record, fasta *faidx.Faidx) {
+	if r.Flags&(bad|sam.Unmapped) != 0 || r.Ref == nil {
+		return
+	}
+	if r.Ref.ID() != p.ref || r.Start()-p.lastFlush >= clipFlushEvery {
+		p.flush(r.Ref.ID(), r.Start())
+		p.ref, p.lastFlush = r.Ref.ID(), r.Start()
+	}
+	seq := r.Seq.Expand()
+	if clip := softClip(r.Cigar, true); clip >= minClipLen {
+		t := 0
+		if fasta != nil {
+			t = max(0, clippedMismatches(r, fasta, true))
+		}
+		q := len(seq) - clip - t
+		p.cluster(r, r.End()-t, 1).append(seq[q:], seq[max(0, q-clipAnchorLen):q])
+	}
+	if clip := softClip(r.Cigar, false); clip >= minClipLen {
+		t := 0
+		if fasta != nil {
+			t = max(0, clippedMismatches(r, fasta, false))
+		}
+		q := clip + t
+		p.cluster(r, r.Start()+t, -1).append(seq[:q], seq[q:min(len(seq), q+clipAnchorLen)])
+	}
+}
+
+func (p *clipPile) cluster(r *sam.Record, pos int, side int8) *clipCluster {
+	k := clipKey{r.Ref.ID(), pos, side}
+	c, ok := p.open[k]
+	if !ok {
+		c = &clipCluster{ref: k.ref, chrom: sstripChr(r.Ref.Name()), pos: pos, side: side}
+		p.open[k] = c
+	}
+	return c
+}
+
+func (c *clipCluster) append(clip, anchor []byte) {
+	c.clips = append(c.clips, clip)
+	c.anchors = append(c.anchors, anchor)
+	c.support++
+}
+
+// flush finishes the clusters that no later record in a sorted stream can add to: those on other
+// chromosomes and those before start. Reads clipped at pos can't start after it, and refined
+// positions only move toward the clip.
+func (p *clipPile) flush(ref, start int) {
+	for k, c := range p.open {
+		if k.ref == ref && k.pos >= start {
+			continue
+		}
+		delete(p.open, k)
+		if c.support < minClipSupport {
+			continue
+		}
+		// the clipped sequence is read away from the clip.
+		c.clip = consensus(c.clips, c.side == -1)
+		c.anchor = consensus(c.anchors, c.side == 1)
+		c.clips, c.anchors = nil, nil
+		if len(c.clip) >= minClipLen && len(c.anchor) >= junctionK {
+			p.done = append(p.done, c)
+		}
+	}
+}
+
+// consensus gives the most common base at each offset of seqs, aligned at their starts or, if
+// fromEnd, at their ends. It stops at the first offset covered by fewer than minConsensusDepth
+// sequences. Offsets without a majority base are N.
+func consensus(seqs [][]byte, fromEnd bool) []byte {
+	var out []byte
+	for i := 0; ; i++ {
+		var counts [5]int
+		depth := 0
+		for _, s := range seqs {
+			if i >= len(s) {
+				continue
+			}
+			b := s[i]
+			if fromEnd {
+				b = s[len(s)-1-i]
+			}
+			counts[baseIndex(b)]++
+			depth++
+		}
+		if depth < minConsensusDepth {
+			break
+		}
+		best := 0
+		for j := 1; j < 4; j++ {
+			if counts[j] > counts[best] {
+				best = j
+			}
+		}
+		b := byte('N')
+		if 2*counts[best] > depth {
+			b = "ACGT"[best]
+		}
+		out = append(out, b)
+	}
+	if fromEnd {
+		for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+			out[i], out[j] = out[j], out[i]
+		}
+	}
+	return out
+}
+
+func baseIndex(b byte) int {
+	switch b &^ 0x20 {
+	case 'A':
+		return 0
+	case 'C':
+		return 1
+	case 'G':
+		return 2
+	case 'T':
+		return 3
+	}
+	return 4
+}
+
+func revComp(s []byte) []byte {
+	out := make([]byte, len(s))
+	for i, b := range s {
+		var c byte
+		switch b &^ 0x20 {
+		case 'A':
+			c = 'T'
+		case 'C':
+			c = 'G'
+		case 'G':
+			c = 'C'
+		case 'T':
+			c = 'A'
+		default:
+			c = 'N'
+		}
+		out[len(s)-1-i] = c
+	}
+	return out
+}
+
+// clipView is a cluster's consensus as one sequence with the junction at j. In an anchor-first view
+// the aligned bases precede the junction; in a clip-first view they follow it. A view is forward if
+// it is on the forward strand of the reference, otherwise it is reverse-complemented so that
+// clusters of the same side (as from inversions) can be joined.
+type clipView struct {
+	c       *clipCluster
+	seq     []byte
+	j       int
+	forward bool
+}
+
+func (c *clipCluster) anchorFirst() clipView {
+	if c.side == 1 {
+		return clipView{c, append(append([]byte{}, c.anchor...), c.clip...), len(c.anchor), true}
+	}
+	return clipView{c, revComp(append(append([]byte{}, c.clip...), c.anchor...)), len(c.anchor), false}
+}
+
+func (c *clipCluster) clipFirst() clipView {
+	if c.side == -1 {
+		return clipView{c, append(append([]byte{}, c.clip...), c.anchor...), len(c.clip), true}
+	}
+	return clipView{c, revComp(append(append([]byte{}, c.anchor...), c.clip...)), len(c.clip), false}
+}
+
+// clipJunction is a pair of clusters whose consensus sequences join: the clipped sequence of each
+// is the aligned sequence of the other. Sequences are on the forward strand of the first end.
+type clipJunction struct {
+	a, b *clipCluster
+	// s1, e1 and s2, e2 are the intervals in which the breakpoint lies. They are longer than
+	// 1 base when there is microhomology.
+	s1, e1, s2, e2 int
+	homology       string
+	insertion      string
+	contig         string
+}
+
+// joinOffset finds the offset o (with b[i] aligning to a[i+o]) at which the clipped sequence of the
+// anchor-first view a matches the aligned sequence of the clip-first view b. It returns false if
+// there is no such offset with at most one mismatch per 10 bases.
+func joinOffset(a, b clipView, offsets []int) (int, bool) {
+	bestO, bestMM, bestShift := 0, -1, 0
+	for _, o := range offsets {
+		lo, hi := max(0, -o), min(len(b.seq), len(a.seq)-o)
+		// the overlap must include the start of the clip of a and the end of the clip of b.
+		if lo > b.j-minClipLen || hi < a.j+minClipLen-o {
+			continue
+		}
+		mm := 0
+		for i := lo; i < hi; i++ {
+			x, y := b.seq[i], a.seq[i+o]
+			if x != y && x != 'N' && y != 'N' {
+				mm++
+			}
+		}
+		if 10*mm > hi-lo {
+			continue
+		}
+		shift := iabs(o + b.j - a.j)
+		if bestMM == -1 || mm < bestMM || mm == bestMM && shift < bestShift {
+			bestO, bestMM, bestShift = o, mm, shift
+		}
+	}
+	return bestO, bestMM >= 0
+}
+
+// breakInterval is the interval in which the breakpoint of c lies given h bases of microhomology.
+func (c *clipCluster) breakInterval(h int) (int, int) {
+	h = max(h, 1)
+	if c.side == 1 {
+		return c.pos - h, c.pos
+	}
+	return c.pos, c.pos + h
+}
+
+func (p *clipPile) join(a, b clipView, o int) *clipJunction {
+	j := &clipJunction{a: a.c, b: b.c}
+	// d is the number of bases between the end of the aligned sequence of a and the start of the
+	// aligned sequence of b. They are inserted if d > 0 and shared if d < 0.
+	d := o + b.j - a.j
+	h := 0
+	var ins, hom []byte
+	if d > 0 {
+		ins = a.seq[a.j : a.j+d]
+	} else if d < 0 {
+		h = -d
+		hom = a.seq[a.j-h : a.j]
+	}
+	contig := a.seq
+	if o < 0 {
+		contig = append(append([]byte{}, b.seq[:-o]...), a.seq...)
+	}
+	if end := len(a.seq) - o; end < len(b.seq) {
+		contig = append(append([]byte{}, contig...), b.seq[end:]...)
+	}
+	j.s1, j.e1 = a.c.breakInterval(h)
+	j.s2, j.e2 = b.c.breakInterval(h)
+	// the joined sequence is in the frame of both views so it is forward on the first end if
+	// that end's view is.
+	forward := a.forward
+	if a.c.ref > b.c.ref || a.c.ref == b.c.ref && a.c.pos > b.c.pos {
+		j.a, j.b = j.b, j.a
+		j.s1, j.e1, j.s2, j.e2 = j.s2, j.e2, j.s1, j.e1
+		forward = b.forward
+	}
+	if !forward {
+		ins, hom, contig = revComp(ins), revComp(hom), revComp(contig)
+	}
+	j.insertion, j.homology, j.contig = string(ins), string(hom), string(contig)
+	return j
+}
+
+// junctions flushes the pile and pairs the clusters whose consensus sequences join.
+func (p *clipPile) junctions() []*clipJunction {
+	p.flush(-1, 0)
+	sort.Slice(p.done, func(i, j int) bool {
+		a, b := p.done[i], p.done[j]
+		if a.ref != b.ref {
+			return a.ref < b.ref
+		}
+		if a.pos != b.pos {
+			return a.pos < b.pos
+		}
+		return a.side < b.side
+	})
+	clipFirst := make([]clipView, len(p.done))
+	index := make(map[string][]int)
+	for i, c := range p.done {
+		v := c.clipFirst()
+		clipFirst[i] = v
+		k := string(v.seq[v.j : v.j+junctionK])
+		index[k] = append(index[k], i)
+	}
+	type pair struct{ x, y int }
+	seen := make(map[pair]bool)
+	var js []*clipJunction
+	for x, c := range p.done {
+		a := c.anchorFirst()
+		offsets := make(map[int][]int)
+		for q := max(0, a.j-maxJunctionShift); q <= min(len(a.seq)-junctionK, a.j+maxJunctionShift); q++ {
+			for _, y := range index[string(a.seq[q:q+junctionK])] {
+				if y != x {
+					offsets[y] = append(offsets[y], q-clipFirst[y].j)
+				}
+			}
+		}
+		ys := make([]int, 0, len(offsets))
+		for y := range offsets {
+			ys = append(ys, y)
+		}
+		sort.Ints(ys)
+		for _, y := range ys {
+			pr := pair{min(x, y), max(x, y)}
+			if seen[pr] {
+				continue
+			}
+			if o, ok := joinOffset(a, clipFirst[y], offsets[y]); ok {
+				seen[pr] = true
+				js = append(js, p.join(a, clipFirst[y], o))
+			}
+		}
+	}
+	return js
+}
+
+// writeClipJunctions pairs the clusters in the pile and writes each junction as a bedpe record of
+// type iclipConsensus and to a report at path. Junctions with an end in the -x blacklist are
+// dropped from both, or flagged in the bedpe with --flag-blacklisted, like other evidence.
+func writeClipJunctions(p *clipPile, ex *excord, path string) {
+	js := p.junctions()
+	log.Printf("found %d soft-clip junctions from %d clusters", len(js), len(p.done))
+	f, err := os.Create(path)
+	pcheck(err)
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, "#chrom1\tstart1\tend1\tside1\tsupport1\tchrom2\tstart2\tend2\tside2\tsupport2\thomology\tinsertion\tcontig")
+	for _, j := range js {
+		if !ex.writeEvidence(&bedPE{j.a.chrom, j.s1, j.e1, j.a.side, j.b.chrom, j.s2, j.e2, j.b.side, iclipConsensus}, false) {
+			continue
+		}
+		_, err = fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n", j.a.chrom, j.s1, j.e1, j.a.side, j.a.support,
+			j.b.chrom, j.s2, j.e2, j.b.side, j.b.support, orDot(j.homology), orDot(j.insertion), j.contig)
+		pcheck(err)
+	}
+	pcheck(w.Flush())
+	pcheck(f.Close())
+}
+
+func orDot(s string) string {
+	if s == "" {
+		return "."
+	}
+	return s
+}
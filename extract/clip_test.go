@@ -0,0 +1,104 @@
+package extract
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Schaudge/ngsutils/intervals"
+)
+
+// pileJunction piles up reads across the junction of refSeq[:50] + ins + refSeq[80:]. The reads
+// that end on the left of it are aligned through hom more bases, as an aligner would do if they
+// matched the right side too.
+func pileJunction(t *testing.T, ins string, hom int) []*clipJunction {
+	t.Helper()
+	return junctionPile(t, ins, hom).junctions()
+}
+
+// junctionPile is the pile that pileJunction finds the junctions of.
+func junctionPile(t *testing.T, ins string, hom int) *clipPile {
+	t.Helper()
+	j := refSeq[:50] + ins + refSeq[80:]
+	p := newClipPile()
+	for _, s := range []int{20 + hom, 22 + hom, 24 + hom} {
+		clip := s + 40 - 50 - hom
+		p.add(newTestRecord(t, "1", s, fmt.Sprintf("%dM%dS", 40-clip, clip), j[s:s+40]), nil)
+	}
+	for _, s := range []int{36, 38, 40} {
+		clip := 50 + len(ins) - s
+		p.add(newTestRecord(t, "1", 80, fmt.Sprintf("%dS%dM", clip, 40-clip), j[s:s+40]), nil)
+	}
+	return p
+}
+
+func TestClipJunctions(t *testing.T) {
+	cases := []struct {
+		name     string
+		ins      string
+		hom      int
+		s1, e1   int
+		s2, e2   int
+		homology string
+	}{
+		{"deletion", "", 0, 49, 50, 80, 81, ""},
+		{"insertion", "GATTACA", 0, 49, 50, 80, 81, ""},
+		{"microhomology", "", 3, 50, 53, 80, 83, refSeq[80:83]},
+	}
+	for _, c := range cases {
+		js := pileJunction(t, c.ins, c.hom)
+		if len(js) != 1 {
+			t.Fatalf("%s: expected 1 junction, got %d", c.name, len(js))
+		}
+		j := js[0]
+		if j.a.side != 1 || j.b.side != -1 || j.s1 != c.s1 || j.e1 != c.e1 || j.s2 != c.s2 || j.e2 != c.e2 {
+			t.Errorf("%s: got %d:%d-%d %d:%d-%d", c.name, j.a.side, j.s1, j.e1, j.b.side, j.s2, j.e2)
+		}
+		if j.insertion != c.ins || j.homology != c.homology {
+			t.Errorf("%s: got insertion %q and homology %q", c.name, j.insertion, j.homology)
+		}
+	}
+}
+
+func TestClipJunctionsNeedSupport(t *testing.T) {
+	p := newClipPile()
+	j := refSeq[:50] + refSeq[80:]
+	p.add(newTestRecord(t, "1", 20, "30M10S", j[20:60]), nil)
+	p.add(newTestRecord(t, "1", 80, "10S30M", j[40:80]), nil)
+	if js := p.junctions(); len(js) != 0 {
+		t.Fatalf("expected no junctions from single reads, got %d", len(js))
+	}
+}
+
+func TestClipJunctionsBlacklist(t *testing.T) {
+	set := intervals.NewSetIndex(sstripChr)
+	set.Add("chr1", 45, 52)
+	set.Index()
+	for _, flag := range []bool{false, true} {
+		bl := &blacklist{set: set, flag: flag}
+		ex := &excord{ch: make(chan alt, 4), blacklist: bl}
+		path := filepath.Join(t.TempDir(), "junctions.txt")
+		writeClipJunctions(junctionPile(t, "", 0), ex, path)
+		close(ex.ch)
+		var alts []alt
+		for a := range ex.ch {
+			alts = append(alts, a)
+		}
+		report, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Count(string(report), "\n")
+		if bl.excludedJunction != 1 {
+			t.Errorf("flag=%v: counted %d blacklisted junctions", flag, bl.excludedJunction)
+		}
+		if !flag && (len(alts) != 0 || lines != 1) {
+			t.Errorf("a blacklisted junction was written: %v and %q", alts, report)
+		}
+		if flag && (len(alts) != 1 || alts[0].excluded != 1 || alts[0].iType != iclipConsensus || lines != 2) {
+			t.Errorf("a blacklisted junction was not flagged: %v and %q", alts, report)
+		}
+	}
+}
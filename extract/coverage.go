@@ -0,0 +1,232 @@
+package extract
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	coverageMagic = "excord-coverage"
+	// coverageVersion is bumped whenever the layout of the .bin files or the
+	// meaning of a field in CoverageMeta changes.
+	// 2: added Quantization. version 1 files that are quantized use the ladder.
+	coverageVersion = 2
+
+	readCoverageFile = "read.bin"
+	pairCoverageFile = "pair.bin"
+	coverageMetaFile = "coverage.json"
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CoverageMeta is the sidecar written next to read.bin and pair.bin. The .bin files are raw
+// little-endian uint16 arrays (so they can be mmap'ed directly) and carry no header of their
+// own; this records everything needed to interpret them and to detect a stale or mismatched pair.
+type CoverageMeta struct {
+	Magic              string `json:"magic"`
+	Version            int    `json:"version"`
+	Program            string `json:"program"`
+	Chrom              string `json:"chrom"`
+	Contig             string `json:"contig,omitempty"`
+	Length             int    `json:"length"`
+	Region             string `json:"region,omitempty"`
+	Quantized          bool   `json:"quantized"`
+	Quantization       string `json:"quantization"`
+	DiscordantDistance int    `json:"discordant_distance"`
+	ExcludeFlag        uint16 `json:"exclude_flag"`
+	MinMappingQuality  uint8  `json:"min_mapping_quality"`
+	ReadCRC            uint32 `json:"read_crc32c"`
+	PairCRC            uint32 `json:"pair_crc32c"`
+}
+
+// contig is the name of the chromosome in the bedpe written with the tracks, which differs from
+// Chrom if it was renamed with --rename.
+func (m *CoverageMeta) contig() string {
+	if m.Contig != "" {
+		return m.Contig
+	}
+	return m.Chrom
+}
+
+// Coverage is a validated view of the coverage tracks excord wrote for one chromosome. The tracks
+// are mmap'ed as they are by the writer, so Read and Pair hold the values as stored and must not
+// be modified; use ReadDepth and PairDepth to get depths. Close unmaps them.
+type Coverage struct {
+	CoverageMeta
+	Read []uint16
+	Pair []uint16
+
+	q Quantizer
+	// read and pair are the read-only mappings of the tracks.
+	read, pair []byte
+}
+
+// Close unmaps the tracks. Read and Pair must not be used after it.
+func (c *Coverage) Close() error {
+	c.Read, c.Pair = nil, nil
+	var err error
+	for _, b := range [][]byte{c.read, c.pair} {
+		if b != nil {
+			if cerr := syscall.Munmap(b); err == nil {
+				err = cerr
+			}
+		}
+	}
+	c.read, c.pair = nil, nil
+	return err
+}
+
+// ReadDepth is the de-quantized read depth at pos.
+func (c *Coverage) ReadDepth(pos int) float64 {
+	return c.q.Dequantize(c.Read[pos])
+}
+
+// PairDepth is the de-quantized depth of fragments spanning pos.
+func (c *Coverage) PairDepth(pos int) float64 {
+	return c.q.Dequantize(c.Pair[pos])
+}
+
+// normalizePrefix makes sure a prefix can have the file names appended directly.
+func normalizePrefix(prefix string) string {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return prefix
+}
+
+func checksum(A []uint16) uint32 {
+	h := crc32.New(crcTable)
+	buf := make([]byte, 2*4096)
+	for len(A) > 0 {
+		n := min(len(A), 4096)
+		for i, v := range A[:n] {
+			binary.LittleEndian.PutUint16(buf[2*i:], v)
+		}
+		h.Write(buf[:2*n])
+		A = A[n:]
+	}
+	return h.Sum32()
+}
+
+// writeMeta records the parameters used to generate the coverage arrays. It must be called
+// after the arrays are final (after quantizing) as it stores their checksums.
+func (ex *excord) writeMeta() error {
+	m := ex.meta
+	m.Magic, m.Version = coverageMagic, coverageVersion
+	if ex.renamer != nil {
+		// a missing contig is an error in strict mode only if it has records.
+		if to, err := ex.renamer.Rename(m.Chrom); err == nil && to != m.Chrom {
+			m.Contig = to
+		}
+	}
+	m.ReadCRC = checksum(ex.readCov.A)
+	m.PairCRC = checksum(ex.pairCov.A)
+	f, err := os.Create(ex.prefix + coverageMetaFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadCoverageMeta reads and validates the sidecar for the given prefix. It does not look at the
+// .bin files themselves.
+func ReadCoverageMeta(prefix string) (*CoverageMeta, error) {
+	prefix = normalizePrefix(prefix)
+	f, err := os.Open(prefix + coverageMetaFile)
+	if err != nil {
+		return nil, fmt.Errorf("excord: missing coverage metadata for %q (written by an older excord?): %w", prefix, err)
+	}
+	defer f.Close()
+	m := &CoverageMeta{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, fmt.Errorf("excord: error reading %s: %w", f.Name(), err)
+	}
+	if m.Magic != coverageMagic {
+		return nil, fmt.Errorf("excord: %s is not an excord coverage file", f.Name())
+	}
+	if m.Version > coverageVersion {
+		return nil, fmt.Errorf("excord: %s has version %d, only versions up to %d are supported", f.Name(), m.Version, coverageVersion)
+	}
+	if m.Length <= 0 {
+		return nil, fmt.Errorf("excord: %s has invalid length: %d", f.Name(), m.Length)
+	}
+	if m.Version < 2 {
+		m.Quantization = "none"
+		if m.Quantized {
+			m.Quantization = DefaultQuantizer
+		}
+	}
+	if _, err := ParseQuantizer(m.Quantization); err != nil {
+		return nil, fmt.Errorf("excord: %s: %w", f.Name(), err)
+	}
+	return m, nil
+}
+
+// OpenCoverage reads the coverage tracks with the given prefix and checks them against their
+// sidecar. If chrom is not empty, it must match the chromosome the tracks were generated for or
+// its name in the bedpe (with or without a "chr" prefix).
+func OpenCoverage(prefix, chrom string) (*Coverage, error) {
+	prefix = normalizePrefix(prefix)
+	m, err := ReadCoverageMeta(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if chrom != "" && sstripChr(chrom) != sstripChr(m.Chrom) && sstripChr(chrom) != sstripChr(m.contig()) {
+		return nil, fmt.Errorf("excord: coverage at %q is for chromosome %s, not %s", prefix, m.Chrom, chrom)
+	}
+	c := &Coverage{CoverageMeta: *m}
+	// already checked in ReadCoverageMeta.
+	c.q, _ = ParseQuantizer(m.Quantization)
+	if c.Read, c.read, err = mapUint16s(prefix+readCoverageFile, m.Length, m.ReadCRC); err != nil {
+		return nil, err
+	}
+	if c.Pair, c.pair, err = mapUint16s(prefix+pairCoverageFile, m.Length, m.PairCRC); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// mapUint16s maps the n values in the file at path read-only and checks them against crc. It
+// returns the values and the mapping to unmap. The file is in native byte order as written by
+// uint16mm.
+func mapUint16s(path string, n int, crc uint32) ([]uint16, []byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.Size() != 2*int64(n) {
+		return nil, nil, fmt.Errorf("excord: %s has %d bytes, expected %d for a chromosome of length %d", path, st.Size(), 2*n, n)
+	}
+	if n == 0 {
+		// an empty file can't be mapped.
+		return []uint16{}, nil, nil
+	}
+	b, err := syscall.Mmap(int(f.Fd()), 0, 2*n, syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, nil, fmt.Errorf("excord: error mapping %s: %w", path, err)
+	}
+	a := unsafe.Slice((*uint16)(unsafe.Pointer(&b[0])), n)
+	if got := checksum(a); got != crc {
+		syscall.Munmap(b)
+		return nil, nil, fmt.Errorf("excord: checksum mismatch for %s; it was modified after its metadata was written", path)
+	}
+	return a, b, nil
+}
@@ -0,0 +1,156 @@
+package extract
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/brentp/mmslice/uint16mm"
+)
+
+// writeTestCoverage writes read and pair as the tracks of chrom the way excord does and returns
+// their prefix.
+func writeTestCoverage(t *testing.T, chrom, quantization string, read, pair []uint16) string {
+	t.Helper()
+	prefix := filepath.Join(t.TempDir(), "s.")
+	ex := &excord{prefix: prefix, meta: &CoverageMeta{Chrom: chrom, Length: len(read), Quantization: quantization,
+		Quantized: quantization != "none", DiscordantDistance: 500, MinMappingQuality: 1}}
+	var err error
+	if ex.readCov, err = uint16mm.Create(prefix+readCoverageFile, int64(len(read))); err != nil {
+		t.Fatal(err)
+	}
+	if ex.pairCov, err = uint16mm.Create(prefix+pairCoverageFile, int64(len(pair))); err != nil {
+		t.Fatal(err)
+	}
+	copy(ex.readCov.A, read)
+	copy(ex.pairCov.A, pair)
+	if err := ex.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return prefix
+}
+
+// editMeta rewrites the sidecar at prefix after applying edit to it.
+func editMeta(t *testing.T, prefix string, edit func(m map[string]interface{})) {
+	t.Helper()
+	b, err := os.ReadFile(prefix + coverageMetaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	edit(m)
+	if b, err = json.Marshal(m); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(prefix+coverageMetaFile, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoverageRoundTrip(t *testing.T) {
+	read := []uint16{0, 1, 7, 9, 30, 255}
+	pair := []uint16{3, 0, 2, 8, 1, 0}
+	prefix := writeTestCoverage(t, "chr2", "none", read, pair)
+
+	m, err := ReadCoverageMeta(strings.TrimSuffix(prefix, "."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Magic != coverageMagic || m.Version != coverageVersion || m.Chrom != "chr2" || m.Length != len(read) ||
+		m.DiscordantDistance != 500 || m.MinMappingQuality != 1 || m.Quantization != "none" {
+		t.Fatalf("ReadCoverageMeta() = %+v", m)
+	}
+
+	// tracks of a cohort may be read-only.
+	for _, f := range []string{readCoverageFile, pairCoverageFile} {
+		if err := os.Chmod(prefix+f, 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c, err := OpenCoverage(prefix, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Read, read) || !reflect.DeepEqual(c.Pair, pair) {
+		t.Fatalf("OpenCoverage() read %v and %v", c.Read, c.Pair)
+	}
+	if c.ReadDepth(2) != 7 || c.PairDepth(3) != 8 || c.refSupport(3) != 9 || c.refSupport(len(read)) != 0 {
+		t.Fatalf("depths are %v %v %d", c.ReadDepth(2), c.PairDepth(3), c.refSupport(3))
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenCoverage(prefix, "chr3"); err == nil {
+		t.Fatal("expected an error for the wrong chromosome")
+	}
+}
+
+func TestCoverageVersion1(t *testing.T) {
+	prefix := writeTestCoverage(t, "1", DefaultQuantizer, []uint16{1, 2}, []uint16{0, 0})
+	editMeta(t, prefix, func(m map[string]interface{}) {
+		m["version"] = 1
+		delete(m, "quantization")
+	})
+	m, err := ReadCoverageMeta(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Quantization != DefaultQuantizer {
+		t.Fatalf("quantized version 1 tracks have quantization %q", m.Quantization)
+	}
+}
+
+func TestCoverageRejected(t *testing.T) {
+	read, pair := []uint16{4, 5, 6, 7}, []uint16{1, 1, 0, 2}
+	for _, tc := range []struct {
+		name    string
+		corrupt func(t *testing.T, prefix string)
+		want    string
+	}{
+		{"magic", func(t *testing.T, prefix string) {
+			editMeta(t, prefix, func(m map[string]interface{}) { m["magic"] = "bedgraph" })
+		}, "not an excord coverage file"},
+		{"version", func(t *testing.T, prefix string) {
+			editMeta(t, prefix, func(m map[string]interface{}) { m["version"] = coverageVersion + 1 })
+		}, "only versions up to"},
+		{"quantization", func(t *testing.T, prefix string) {
+			editMeta(t, prefix, func(m map[string]interface{}) { m["quantization"] = "log:0" })
+		}, "quantiz"},
+		{"missing", func(t *testing.T, prefix string) {
+			if err := os.Remove(prefix + coverageMetaFile); err != nil {
+				t.Fatal(err)
+			}
+		}, "missing coverage metadata"},
+		{"crc", func(t *testing.T, prefix string) {
+			b, err := os.ReadFile(prefix + readCoverageFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b[2]++
+			if err := os.WriteFile(prefix+readCoverageFile, b, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}, "checksum mismatch"},
+		{"size", func(t *testing.T, prefix string) {
+			if err := os.Truncate(prefix+pairCoverageFile, 6); err != nil {
+				t.Fatal(err)
+			}
+		}, "expected 8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prefix := writeTestCoverage(t, "1", "none", read, pair)
+			tc.corrupt(t, prefix)
+			_, err := OpenCoverage(prefix, "")
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("OpenCoverage() gave error %v, want one with %q", err, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,492 @@
+package extract
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/brentp/xopen"
+)
+
+type genotypeArgs struct {
+	DiscordantDistance int     `arg:"-d,help:window used for discordant evidence. if not given it is read from each sample's coverage metadata"`
+	ErrorRate          float64 `arg:"-e,help:probability that a fragment supports the allele the sample does not carry"`
+	Candidates         string  `arg:"positional,required,help:candidate SVs as BEDPE or VCF"`
+	SampleSheet        string  `arg:"positional,required,help:tab-delimited lines of sample name then excord bedpe then one or more coverage prefixes"`
+}
+
+// candidate is a pair of breakpoints to genotype. positions are 0-based.
+type candidate struct {
+	id     string
+	c1     string
+	p1     int
+	c2     string
+	p2     int
+	refs   [][2]int // per sample, reference support at each end. -1 if there is no coverage track.
+	alts   []int
+	result []gtCall
+}
+
+type gtCall struct {
+	gt  string
+	ref int
+	alt int
+	gq  int
+	gl  [3]float64
+}
+
+// evidenceWindow holds the intervals at either end of an excord bedpe record in which the
+// breakpoint it supports should lie (see bedPE.altWindow).
+type evidenceWindow struct {
+	s1, e1, s2, e2 int
+}
+
+type gtSample struct {
+	name     string
+	prefixes map[string]string
+	distance int
+	// evidence is keyed by chrom1 + "\t" + chrom2 and sorted by s1.
+	evidence map[string][]evidenceWindow
+	maxSpan  int
+}
+
+// Genotype is the entry-point for the genotype sub-command. It jointly genotypes a set of
+// candidate SVs across samples that have been run through excord with a coverage prefix.
+func Genotype() {
+	cli := &genotypeArgs{ErrorRate: 0.05}
+	arg.MustParse(cli)
+
+	cands, err := readCandidates(cli.Candidates)
+	pcheck(err)
+	samples, err := readSampleSheet(cli.SampleSheet, cli.DiscordantDistance)
+	pcheck(err)
+	log.Printf("genotyping %d candidates in %d samples", len(cands), len(samples))
+
+	for _, c := range cands {
+		c.refs = make([][2]int, len(samples))
+		c.alts = make([]int, len(samples))
+		for si, s := range samples {
+			c.alts[si] = s.altCount(c)
+		}
+	}
+	pcheck(fillReferenceSupport(cands, samples))
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	names := make([]string, len(samples))
+	for i, s := range samples {
+		names[i] = s.name
+	}
+	fmt.Fprintf(w, "##format=GT:REF:ALT:GQ:GL\n#chrom1\tpos1\tchrom2\tpos2\tid\taf\t%s\n", strings.Join(names, "\t"))
+	for _, c := range cands {
+		af := c.genotype(cli.ErrorRate)
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%.4f", c.c1, c.p1, c.c2, c.p2, c.id, af)
+		for _, r := range c.result {
+			fmt.Fprintf(w, "\t%s:%d:%d:%d:%.2f,%.2f,%.2f", r.gt, r.ref, r.alt, r.gq, r.gl[0], r.gl[1], r.gl[2])
+		}
+		pcheck(w.WriteByte('\n'))
+	}
+}
+
+// fillReferenceSupport sets the reference support for each candidate end. It loads one
+// chromosome for one sample at a time to bound memory.
+func fillReferenceSupport(cands []*candidate, samples []*gtSample) error {
+	byChrom := make(map[string][]*candidate)
+	for _, c := range cands {
+		for i := range c.refs {
+			c.refs[i] = [2]int{-1, -1}
+		}
+		byChrom[c.c1] = append(byChrom[c.c1], c)
+		if c.c2 != c.c1 {
+			byChrom[c.c2] = append(byChrom[c.c2], c)
+		}
+	}
+	for chrom, cs := range byChrom {
+		for si, s := range samples {
+			prefix, ok := s.prefixes[chrom]
+			if !ok {
+				continue
+			}
+			cov, err := OpenCoverage(prefix, chrom)
+			if err != nil {
+				return err
+			}
+			for _, c := range cs {
+				if c.c1 == chrom {
+					c.refs[si][0] = cov.refSupport(c.p1)
+				}
+				if c.c2 == chrom {
+					c.refs[si][1] = cov.refSupport(c.p2)
+				}
+			}
+			if err := cov.Close(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// refSupport is the number of fragments that support the reference at pos.
+func (c *Coverage) refSupport(pos int) int {
+	if pos < 0 || pos >= len(c.Read) {
+		return 0
+	}
+	return int(math.Round(math.Max(c.ReadDepth(pos), c.PairDepth(pos))))
+}
+
+// altCount is the number of evidence records for the sample that support both ends of c.
+func (s *gtSample) altCount(c *candidate) int {
+	n := s.countPairs(c.c1, c.p1, c.c2, c.p2)
+	if c.c1 != c.c2 {
+		n += s.countPairs(c.c2, c.p2, c.c1, c.p1)
+	}
+	return n
+}
+
+func (s *gtSample) countPairs(c1 string, p1 int, c2 string, p2 int) int {
+	ws := s.evidence[c1+"\t"+c2]
+	i := sort.Search(len(ws), func(i int) bool { return ws[i].s1 > p1-s.maxSpan })
+	n := 0
+	for ; i < len(ws) && ws[i].s1 <= p1; i++ {
+		w := ws[i]
+		if p1 < w.e1 && w.s2 <= p2 && p2 < w.e2 {
+			n++
+		}
+	}
+	return n
+}
+
+// genotypeLikelihoods gives the log10 likelihoods of 0/0, 0/1 and 1/1 given the counts.
+func genotypeLikelihoods(ref, alt int, errorRate float64) [3]float64 {
+	var gl [3]float64
+	for i, p := range [3]float64{errorRate, 0.5, 1 - errorRate} {
+		gl[i] = float64(alt)*math.Log10(p) + float64(ref)*math.Log10(1-p)
+	}
+	return gl
+}
+
+func hwePriors(af float64) [3]float64 {
+	return [3]float64{(1 - af) * (1 - af), 2 * af * (1 - af), af * af}
+}
+
+// posteriors combines log10 likelihoods with priors and normalizes.
+func posteriors(gl [3]float64, priors [3]float64) [3]float64 {
+	m := math.Max(gl[0], math.Max(gl[1], gl[2]))
+	var p [3]float64
+	sum := 0.0
+	for i := range p {
+		p[i] = math.Pow(10, gl[i]-m) * priors[i]
+		sum += p[i]
+	}
+	for i := range p {
+		p[i] /= sum
+	}
+	return p
+}
+
+// estimateAF finds the cohort allele frequency by EM over the samples' genotype likelihoods.
+func estimateAF(gls [][3]float64) float64 {
+	if len(gls) == 0 {
+		return 0
+	}
+	af := 0.1
+	for iter := 0; iter < 100; iter++ {
+		pri := hwePriors(af)
+		sum := 0.0
+		for _, gl := range gls {
+			p := posteriors(gl, pri)
+			sum += p[1] + 2*p[2]
+		}
+		naf := sum / float64(2*len(gls))
+		if math.Abs(naf-af) < 1e-6 {
+			return naf
+		}
+		af = naf
+	}
+	return af
+}
+
+// genotype sets c.result and returns the estimated allele frequency.
+func (c *candidate) genotype(errorRate float64) float64 {
+	c.result = make([]gtCall, len(c.alts))
+	var gls [][3]float64
+	for i := range c.alts {
+		r := &c.result[i]
+		r.alt = c.alts[i]
+		r1, r2 := c.refs[i][0], c.refs[i][1]
+		switch {
+		case r1 >= 0 && r2 >= 0:
+			r.ref = (r1 + r2 + 1) / 2
+		case r1 >= 0:
+			r.ref = r1
+		case r2 >= 0:
+			r.ref = r2
+		}
+		r.gl = genotypeLikelihoods(r.ref, r.alt, errorRate)
+		if r.ref+r.alt > 0 {
+			gls = append(gls, r.gl)
+		}
+	}
+	af := estimateAF(gls)
+	pri := hwePriors(af)
+	for i := range c.result {
+		r := &c.result[i]
+		if r.ref+r.alt == 0 {
+			r.gt = "./."
+			continue
+		}
+		p := posteriors(r.gl, pri)
+		best := 0
+		for g := 1; g < 3; g++ {
+			if p[g] > p[best] {
+				best = g
+			}
+		}
+		r.gt = [3]string{"0/0", "0/1", "1/1"}[best]
+		r.gq = 200
+		if e := 1 - p[best]; e > 1e-20 {
+			r.gq = min(200, int(math.Round(-10*math.Log10(e))))
+		}
+	}
+	return af
+}
+
+func readSampleSheet(path string, discordantDistance int) ([]*gtSample, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	var samples []*gtSample
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line != "" && line[0] != '#' {
+			toks := strings.Split(line, "\t")
+			if len(toks) < 3 {
+				return nil, fmt.Errorf("excord: expected sample, bedpe and coverage prefix in sample sheet line: %q", line)
+			}
+			s := &gtSample{name: toks[0], prefixes: make(map[string]string), distance: discordantDistance}
+			for _, prefix := range toks[2:] {
+				m, err := ReadCoverageMeta(prefix)
+				if err != nil {
+					return nil, err
+				}
+				s.prefixes[sstripChr(m.contig())] = prefix
+				if discordantDistance == 0 {
+					s.distance = max(s.distance, m.DiscordantDistance)
+				}
+			}
+			if s.distance == 0 {
+				return nil, fmt.Errorf("excord: no discordant distance found for sample %s", s.name)
+			}
+			if err := s.readEvidence(toks[1]); err != nil {
+				return nil, err
+			}
+			samples = append(samples, s)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("excord: no samples found in %s", path)
+	}
+	return samples, nil
+}
+
+// readEvidence loads the bedpe written by excord for a sample.
+func (s *gtSample) readEvidence(path string) error {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+	s.evidence = make(map[string][]evidenceWindow)
+	for {
+		line, err := rdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" && line[0] != '#' {
+			a, perr := parseBedPE(line)
+			if perr != nil {
+				return fmt.Errorf("excord: error parsing %s: %w", path, perr)
+			}
+			// records with an end in the blacklist are only kept when they are flagged; they are
+			// not evidence. "-1" is the contig of a record whose mate is unmapped.
+			if b := a.bedPE; a.excluded == 0 && b.c2 != "-1" {
+				var w evidenceWindow
+				w.s1, w.e1 = b.altWindow(1, s.distance)
+				w.s2, w.e2 = b.altWindow(2, s.distance)
+				// candidates have no "chr" prefix.
+				key := sstripChr(b.c1) + "\t" + sstripChr(b.c2)
+				s.evidence[key] = append(s.evidence[key], w)
+				s.maxSpan = max(s.maxSpan, w.e1-w.s1)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	for _, ws := range s.evidence {
+		sort.Slice(ws, func(i, j int) bool { return ws[i].s1 < ws[j].s1 })
+	}
+	return nil
+}
+
+// parseBedPE parses a line as written by excord, with the ends in the blacklist from the column
+// added by --flag-blacklisted.
+func parseBedPE(line string) (alt, error) {
+	toks := strings.Split(line, "\t")
+	if len(toks) < 9 {
+		return alt{}, fmt.Errorf("expected 9 columns in %q", line)
+	}
+	var ints [7]int
+	for i, col := range []int{1, 2, 3, 5, 6, 7, 8} {
+		v, err := strconv.Atoi(toks[col])
+		if err != nil {
+			return alt{}, err
+		}
+		ints[i] = v
+	}
+	a := alt{bedPE: &bedPE{toks[0], ints[0], ints[1], int8(ints[2]), toks[4], ints[3], ints[4], int8(ints[5]), ints[6]}}
+	if len(toks) > 9 {
+		v, err := strconv.ParseInt(toks[9], 10, 8)
+		if err != nil {
+			return alt{}, err
+		}
+		a.excluded = int8(v)
+	}
+	return a, nil
+}
+
+var bndAlt = regexp.MustCompile(`[\[\]]([^:\[\]]+):(\d+)[\[\]]`)
+
+// readCandidates reads candidate SVs from a BEDPE (using the midpoint of each interval) or a VCF
+// (using POS and END, CHR2 or the mate in a BND alt). Records that can't be parsed are logged and
+// skipped.
+func readCandidates(path string) ([]*candidate, error) {
+	rdr, err := xopen.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	isVCF := strings.HasSuffix(path, ".vcf") || strings.HasSuffix(path, ".vcf.gz")
+	seenMates := make(map[string]bool)
+	var cands []*candidate
+	lineNo, skipped := 0, 0
+	for {
+		line, err := rdr.ReadString('\n')
+		lineNo++
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.HasPrefix(line, "##fileformat=VCF") {
+			isVCF = true
+		}
+		if line != "" && line[0] != '#' {
+			var c *candidate
+			var perr error
+			if isVCF {
+				c, perr = parseVCFCandidate(line, seenMates)
+			} else {
+				c, perr = parseBedPECandidate(line)
+			}
+			if perr != nil {
+				// one record that can't be genotyped, such as an INS without END, doesn't stop
+				// the others.
+				log.Printf("excord: skipping candidate at line %d of %s: %v", lineNo, path, perr)
+				skipped++
+				c = nil
+			}
+			if c != nil {
+				if c.c1 == c.c2 && c.p2 < c.p1 {
+					c.p1, c.p2 = c.p2, c.p1
+				}
+				cands = append(cands, c)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	if skipped > 0 {
+		log.Printf("excord: skipped %d candidates in %s that could not be parsed", skipped, path)
+	}
+	return cands, nil
+}
+
+func parseBedPECandidate(line string) (*candidate, error) {
+	toks := strings.Split(line, "\t")
+	if len(toks) < 6 {
+		return nil, fmt.Errorf("expected at least 6 columns in %q", line)
+	}
+	var ints [4]int
+	for i, col := range []int{1, 2, 4, 5} {
+		v, err := strconv.Atoi(toks[col])
+		if err != nil {
+			return nil, err
+		}
+		ints[i] = v
+	}
+	c := &candidate{c1: sstripChr(toks[0]), p1: (ints[0] + ints[1]) / 2, c2: sstripChr(toks[3]), p2: (ints[2] + ints[3]) / 2, id: "."}
+	if len(toks) > 6 {
+		c.id = toks[6]
+	}
+	return c, nil
+}
+
+// parseVCFCandidate returns nil for the second record of a BND pair that was already seen.
+func parseVCFCandidate(line string, seenMates map[string]bool) (*candidate, error) {
+	toks := strings.SplitN(line, "\t", 9)
+	if len(toks) < 8 {
+		return nil, fmt.Errorf("expected at least 8 columns in %q", line)
+	}
+	pos, err := strconv.Atoi(toks[1])
+	if err != nil {
+		return nil, err
+	}
+	c := &candidate{c1: sstripChr(toks[0]), p1: pos - 1, c2: sstripChr(toks[0]), p2: -1, id: toks[2]}
+	for _, kv := range strings.Split(toks[7], ";") {
+		k, v, _ := strings.Cut(kv, "=")
+		switch k {
+		case "END":
+			if c.p2, err = strconv.Atoi(v); err != nil {
+				return nil, err
+			}
+			c.p2--
+		case "CHR2":
+			c.c2 = sstripChr(v)
+		case "MATEID":
+			if seenMates[toks[2]] {
+				return nil, nil
+			}
+			seenMates[v] = true
+		}
+	}
+	if m := bndAlt.FindStringSubmatch(toks[4]); m != nil {
+		c.c2 = sstripChr(m[1])
+		if c.p2, err = strconv.Atoi(m[2]); err != nil {
+			return nil, err
+		}
+		c.p2--
+	}
+	if c.p2 < 0 {
+		return nil, fmt.Errorf("could not find the second breakpoint for %s:%d", toks[0], pos)
+	}
+	return c, nil
+}
@@ -0,0 +1,183 @@
+package extract
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestGenotypeLikelihoods(t *testing.T) {
+	l := math.Log10
+	for _, tc := range []struct {
+		ref, alt int
+		e        float64
+		want     [3]float64
+	}{
+		{0, 0, 0.05, [3]float64{0, 0, 0}},
+		{10, 0, 0.05, [3]float64{10 * l(0.95), 10 * l(0.5), 10 * l(0.05)}},
+		{0, 10, 0.05, [3]float64{10 * l(0.05), 10 * l(0.5), 10 * l(0.95)}},
+		{5, 5, 0.05, [3]float64{5*l(0.05) + 5*l(0.95), 10 * l(0.5), 5*l(0.95) + 5*l(0.05)}},
+		{3, 1, 0.1, [3]float64{l(0.1) + 3*l(0.9), 4 * l(0.5), l(0.9) + 3*l(0.1)}},
+	} {
+		got := genotypeLikelihoods(tc.ref, tc.alt, tc.e)
+		for i := range got {
+			if math.Abs(got[i]-tc.want[i]) > 1e-9 {
+				t.Errorf("genotypeLikelihoods(%d, %d, %v) = %v, want %v", tc.ref, tc.alt, tc.e, got, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestEstimateAF(t *testing.T) {
+	gls := func(ref, alt, n int) [][3]float64 {
+		var out [][3]float64
+		for i := 0; i < n; i++ {
+			out = append(out, genotypeLikelihoods(ref, alt, 0.05))
+		}
+		return out
+	}
+	if af := estimateAF(nil); af != 0 {
+		t.Fatalf("estimateAF(nil) = %v", af)
+	}
+	if af := estimateAF(gls(20, 0, 10)); af > 1e-3 {
+		t.Fatalf("no alt support gave an allele frequency of %v", af)
+	}
+	if af := estimateAF(gls(0, 20, 10)); af < 1-1e-3 {
+		t.Fatalf("only alt support gave an allele frequency of %v", af)
+	}
+	if af := estimateAF(gls(10, 10, 10)); math.Abs(af-0.5) > 1e-3 {
+		t.Fatalf("all hets gave an allele frequency of %v", af)
+	}
+	// one 1/1 and three 0/0 is 2 alleles in 8.
+	mixed := append(gls(0, 30, 1), gls(30, 0, 3)...)
+	af := estimateAF(mixed)
+	if math.Abs(af-0.25) > 1e-3 {
+		t.Fatalf("estimateAF() = %v, want 0.25", af)
+	}
+	// the estimate is a fixed point of the EM.
+	sum := 0.0
+	for _, gl := range mixed {
+		p := posteriors(gl, hwePriors(af))
+		sum += p[1] + 2*p[2]
+	}
+	if math.Abs(sum/8-af) > 1e-5 {
+		t.Fatalf("estimateAF() = %v did not converge; the next step is %v", af, sum/8)
+	}
+}
+
+func TestCandidateGenotype(t *testing.T) {
+	c := &candidate{refs: [][2]int{{20, 20}, {10, -1}, {-1, -1}, {0, 2}}, alts: []int{0, 10, 0, 20}}
+	c.genotype(0.05)
+	var gts []string
+	for _, r := range c.result {
+		gts = append(gts, r.gt)
+	}
+	if want := []string{"0/0", "0/1", "./.", "1/1"}; !reflect.DeepEqual(gts, want) {
+		t.Fatalf("genotypes are %v, want %v", gts, want)
+	}
+	if c.result[0].ref != 20 || c.result[1].ref != 10 || c.result[3].ref != 1 {
+		t.Fatalf("reference support is %+v", c.result)
+	}
+}
+
+func TestParseVCFCandidate(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, tc := range []struct {
+		line string
+		want *candidate
+	}{
+		{"chr1\t100\tdel1\tN\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=500", &candidate{id: "del1", c1: "1", p1: 99, c2: "1", p2: 499}},
+		{"1\t100\ttra1\tN\t<TRA>\t.\tPASS\tSVTYPE=TRA;CHR2=chr5;END=900\tGT\t0/1", &candidate{id: "tra1", c1: "1", p1: 99, c2: "5", p2: 899}},
+		{"chr2\t321\tbnd1\tN\tN]chr13:123]\t.\tPASS\tSVTYPE=BND;MATEID=bnd2", &candidate{id: "bnd1", c1: "2", p1: 320, c2: "13", p2: 122}},
+		{"chr13\t123\tbnd2\tN\tN]chr2:321]\t.\tPASS\tSVTYPE=BND;MATEID=bnd1", nil},
+		{"3\t50\tbnd3\tA\t[X:7[A\t.\tPASS\tSVTYPE=BND", &candidate{id: "bnd3", c1: "3", p1: 49, c2: "X", p2: 6}},
+	} {
+		got, err := parseVCFCandidate(tc.line, seen)
+		if err != nil {
+			t.Fatalf("parseVCFCandidate(%q): %v", tc.line, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseVCFCandidate(%q) = %+v, want %+v", tc.line, got, tc.want)
+		}
+	}
+	for _, line := range []string{
+		"1\t100\tins\tN\t<INS>\t.\tPASS\tSVTYPE=INS",
+		"1\tx\tdel\tN\t<DEL>\t.\tPASS\tEND=500",
+		"1\t100\tdel\tN\t<DEL>\t.\tPASS\tEND=5e2",
+		"1\t100\tdel\tN\t<DEL>",
+	} {
+		if _, err := parseVCFCandidate(line, seen); err == nil {
+			t.Errorf("expected an error for %q", line)
+		}
+	}
+}
+
+func TestReadCandidates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.vcf")
+	vcf := "##fileformat=VCFv4.2\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n" +
+		"chr1\t100\tins1\tN\t<INS>\t.\tPASS\tSVTYPE=INS\n" +
+		"chr1\t600\tdel1\tN\t<DEL>\t.\tPASS\tSVTYPE=DEL;END=200\n" +
+		"chr1\t700\tcnv1\tN\t<CNV:TR>\t.\tPASS\tSVTYPE=CNV\n"
+	if err := os.WriteFile(path, []byte(vcf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cands, err := readCandidates(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the ends of an intrachromosomal candidate are ordered.
+	want := []*candidate{{id: "del1", c1: "1", p1: 199, c2: "1", p2: 599}}
+	if !reflect.DeepEqual(cands, want) {
+		t.Fatalf("readCandidates() = %+v, want %+v", cands, want)
+	}
+}
+
+func TestReadEvidence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "s.bedpe")
+	bedpe := "" +
+		// discordant pairs; the contigs have a "chr" prefix unlike the candidates.
+		"chr1\t1000\t1100\t1\tchr1\t5000\t5100\t-1\t0\t0\n" +
+		"chr1\t1050\t1150\t1\tchr1\t5050\t5150\t-1\t0\t0\n" +
+		// an end in the blacklist.
+		"chr1\t1020\t1120\t1\tchr1\t5020\t5120\t-1\t0\t2\n" +
+		// mate unmapped.
+		"chr1\t1000\t1100\t1\t-1\t-1\t-1\t0\t0\t0\n" +
+		"chr1\t900\t1000\t1\tchr7\t300\t400\t-1\t0\t0\n"
+	if err := os.WriteFile(path, []byte(bedpe), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &gtSample{distance: 500}
+	if err := s.readEvidence(path); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.evidence["1\t1"]); n != 2 {
+		t.Fatalf("found %d records for 1:1 in %v", n, s.evidence)
+	}
+	for _, tc := range []struct {
+		c    *candidate
+		want int
+	}{
+		{&candidate{c1: "1", p1: 1200, c2: "1", p2: 5005}, 2},
+		{&candidate{c1: "1", p1: 1120, c2: "1", p2: 5005}, 1},
+		{&candidate{c1: "1", p1: 1200, c2: "1", p2: 9000}, 0},
+		{&candidate{c1: "2", p1: 1200, c2: "2", p2: 5005}, 0},
+		// the ends of an interchromosomal candidate can be in either order.
+		{&candidate{c1: "7", p1: 300, c2: "1", p2: 1010}, 1},
+		{&candidate{c1: "1", p1: 1010, c2: "7", p2: 300}, 1},
+	} {
+		if got := s.altCount(tc.c); got != tc.want {
+			t.Errorf("altCount(%+v) = %d, want %d", tc.c, got, tc.want)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte("chr1\t1000\t1100\t1\tchr1\t5000\t5100\t-1\t0\tx\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.readEvidence(path); err == nil {
+		t.Fatal("expected an error for a bad blacklist column")
+	}
+}
@@ -0,0 +1,117 @@
+package extract
+
+import "math/bits"
+
+const (
+	maskPageBits  = 1 << 16
+	maskPageWords = maskPageBits / 64
+)
+
+type maskPage [maskPageWords]uint64
+
+// altMask tracks, for each base of a chromosome, whether it falls in at least one (once) and at
+// least two (twice) alt windows. It has the same semantics as a pair of []bool the length of the
+// chromosome, but bits are stored in pages that are only allocated once a window touches them, so
+// long chromosomes with sparse alt evidence cost little more than the page tables.
+type altMask struct {
+	n     int
+	once  []*maskPage
+	twice []*maskPage
+}
+
+func newAltMask(n int) *altMask {
+	pages := (n + maskPageBits - 1) / maskPageBits
+	return &altMask{n: n, once: make([]*maskPage, pages), twice: make([]*maskPage, pages)}
+}
+
+// Len is the length of the chromosome covered by the mask.
+func (m *altMask) Len() int {
+	return m.n
+}
+
+// add marks [s, e) as covered by another alt window. It is clipped to the chromosome.
+func (m *altMask) add(s, e int) {
+	if s < 0 {
+		s = 0
+	}
+	if e > m.n {
+		e = m.n
+	}
+	for s < e {
+		w := s / 64
+		// bits s%64 up to the end of the interval or of the word.
+		hi := min(e-w*64, 64)
+		bitsSet := ^uint64(0) << uint(s%64)
+		if hi < 64 {
+			bitsSet &= (1 << uint(hi)) - 1
+		}
+		p, pw := w/maskPageWords, w%maskPageWords
+		if m.once[p] == nil {
+			m.once[p] = &maskPage{}
+		}
+		if both := m.once[p][pw] & bitsSet; both != 0 {
+			if m.twice[p] == nil {
+				m.twice[p] = &maskPage{}
+			}
+			m.twice[p][pw] |= both
+		}
+		m.once[p][pw] |= bitsSet
+		s = (w + 1) * 64
+	}
+}
+
+func getBit(pages []*maskPage, i int) bool {
+	p := pages[i/maskPageBits]
+	if p == nil {
+		return false
+	}
+	w := (i % maskPageBits) / 64
+	return p[w]&(1<<uint(i%64)) != 0
+}
+
+// Once reports whether base i is in at least one alt window.
+func (m *altMask) Once(i int) bool {
+	return getBit(m.once, i)
+}
+
+// Twice reports whether base i is in at least two alt windows.
+func (m *altMask) Twice(i int) bool {
+	return getBit(m.twice, i)
+}
+
+func countBits(pages []*maskPage) int {
+	n := 0
+	for _, p := range pages {
+		if p == nil {
+			continue
+		}
+		for _, w := range p {
+			n += bits.OnesCount64(w)
+		}
+	}
+	return n
+}
+
+// CountOnce is the number of bases in at least one alt window.
+func (m *altMask) CountOnce() int {
+	return countBits(m.once)
+}
+
+// CountTwice is the number of bases in at least two alt windows.
+func (m *altMask) CountTwice() int {
+	return countBits(m.twice)
+}
+
+// Bytes is the memory used by the allocated pages and page tables.
+func (m *altMask) Bytes() int {
+	n := 8 * (len(m.once) + len(m.twice))
+	for i := range m.once {
+		if m.once[i] != nil {
+			n += 8 * maskPageWords
+		}
+		if m.twice[i] != nil {
+			n += 8 * maskPageWords
+		}
+	}
+	return n
+}
@@ -0,0 +1,107 @@
+package extract
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// chr1Len is the length of chr1 in GRCh38.
+const chr1Len = 248956422
+
+// boolMask is the []bool representation that altMask replaced. It is kept here as the reference
+// for the semantics and as the baseline for the benchmarks.
+type boolMask struct {
+	once, twice []bool
+}
+
+func (m *boolMask) add(s, e int) {
+	if s < 0 {
+		s = 0
+	}
+	if e > len(m.once) {
+		e = len(m.once)
+	}
+	for i := s; i < e; i++ {
+		if m.once[i] {
+			m.twice[i] = true
+		} else {
+			m.once[i] = true
+		}
+	}
+}
+
+// windows are like those from updateMask: short splitter windows and longer discordant windows
+// clustered in a few hot-spots plus background noise.
+func windows(n, chromLen int) [][2]int {
+	r := rand.New(rand.NewSource(42))
+	ws := make([][2]int, n)
+	hot := []int{chromLen / 7, chromLen / 3, chromLen / 2, 4 * chromLen / 5}
+	for i := range ws {
+		var p int
+		if i%3 == 0 {
+			p = r.Intn(chromLen)
+		} else {
+			p = hot[r.Intn(len(hot))] + r.Intn(1e6)
+		}
+		l := 20
+		if i%2 == 0 {
+			l = 10 + r.Intn(600)
+		}
+		ws[i] = [2]int{p - 10, p - 10 + l}
+	}
+	return ws
+}
+
+func TestAltMaskMatchesBoolMask(t *testing.T) {
+	n := 3*maskPageBits + 123
+	m := newAltMask(n)
+	b := &boolMask{once: make([]bool, n), twice: make([]bool, n)}
+	for _, w := range windows(5000, n) {
+		m.add(w[0], w[1])
+		b.add(w[0], w[1])
+	}
+	// edges of the chromosome and of words.
+	for _, w := range [][2]int{{-5, 3}, {n - 3, n + 10}, {63, 65}, {64, 128}, {0, 1}} {
+		m.add(w[0], w[1])
+		b.add(w[0], w[1])
+	}
+	once, twice := 0, 0
+	for i := 0; i < n; i++ {
+		if m.Once(i) != b.once[i] || m.Twice(i) != b.twice[i] {
+			t.Fatalf("mismatch at %d: got %v/%v, want %v/%v", i, m.Once(i), m.Twice(i), b.once[i], b.twice[i])
+		}
+		if b.once[i] {
+			once++
+		}
+		if b.twice[i] {
+			twice++
+		}
+	}
+	if m.CountOnce() != once || m.CountTwice() != twice {
+		t.Fatalf("counts: got %d/%d, want %d/%d", m.CountOnce(), m.CountTwice(), once, twice)
+	}
+}
+
+func BenchmarkAltMaskChr1Bool(b *testing.B) {
+	ws := windows(500000, chr1Len)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m := &boolMask{once: make([]bool, chr1Len), twice: make([]bool, chr1Len)}
+		for _, w := range ws {
+			m.add(w[0], w[1])
+		}
+		b.ReportMetric(float64(2*chr1Len)/1e6, "MB/mask")
+	}
+}
+
+func BenchmarkAltMaskChr1Sparse(b *testing.B) {
+	ws := windows(500000, chr1Len)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m := newAltMask(chr1Len)
+		for _, w := range ws {
+			m.add(w[0], w[1])
+		}
+		b.ReportMetric(float64(m.Bytes())/1e6, "MB/mask")
+	}
+}
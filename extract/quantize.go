@@ -0,0 +1,133 @@
+package extract
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Quantizer maps a depth to the value stored in a coverage track. Stored values stay in units of
+// depth so that Dequantize gives a depth for every position of a track.
+type Quantizer interface {
+	Quantize(v uint16) uint16
+	// Dequantize gives the expected depth for a stored value.
+	Dequantize(v uint16) float64
+	// Spec is the string that ParseQuantizer turns back into an equivalent Quantizer.
+	Spec() string
+}
+
+// DefaultQuantizer is the fixed ladder that excord has always used.
+const DefaultQuantizer = "ladder"
+
+// ParseQuantizer returns the scheme for spec, which is one of:
+// "none"; "ladder" (exact < 7, even < 18, nearest 5 < 48, nearest 9 < 84, nearest 17 above);
+// "log" or "log:N" for N bins per doubling of depth; or comma-separated, increasing bin starts
+// such as "0,1,2,4,8,16,32,64,128".
+func ParseQuantizer(spec string) (Quantizer, error) {
+	switch {
+	case spec == "none":
+		return noQuantizer{}, nil
+	case spec == "" || spec == DefaultQuantizer:
+		return ladderQuantizer{}, nil
+	case spec == "log" || strings.HasPrefix(spec, "log:"):
+		steps := 4
+		if spec != "log" {
+			var err error
+			if steps, err = strconv.Atoi(spec[4:]); err != nil || steps < 1 {
+				return nil, fmt.Errorf("excord: invalid steps in quantization %q", spec)
+			}
+		}
+		return newLogQuantizer(steps), nil
+	}
+	var starts []uint16
+	for _, tok := range strings.Split(spec, ",") {
+		v, err := strconv.Atoi(strings.TrimSpace(tok))
+		if err != nil || v < 0 || v > math.MaxUint16 {
+			return nil, fmt.Errorf("excord: invalid quantization %q: bin starts must be integers between 0 and %d", spec, math.MaxUint16)
+		}
+		starts = append(starts, uint16(v))
+	}
+	return newBinQuantizer(spec, starts)
+}
+
+// storesDepths reports whether q stores depths as they are, as the ladder and none do. Their
+// tracks are capped at 255 and positions in alt regions keep their exact depth. Other schemes see
+// the whole depth and store every position, including those in alt regions, as its bin.
+func storesDepths(q Quantizer) bool {
+	switch q.(type) {
+	case noQuantizer, ladderQuantizer:
+		return true
+	}
+	return false
+}
+
+type noQuantizer struct{}
+
+func (noQuantizer) Quantize(v uint16) uint16    { return v }
+func (noQuantizer) Dequantize(v uint16) float64 { return float64(v) }
+func (noQuantizer) Spec() string                { return "none" }
+
+type ladderQuantizer struct{}
+
+func (ladderQuantizer) Quantize(v uint16) uint16 { return _quantize(v) }
+
+// the ladder rounds to the middle of each bin so the stored value is already the expected depth.
+func (ladderQuantizer) Dequantize(v uint16) float64 { return float64(v) }
+func (ladderQuantizer) Spec() string                { return DefaultQuantizer }
+
+// binQuantizer stores the middle of the bin that a depth falls in. Depths at or above the last
+// start are stored as the last start.
+type binQuantizer struct {
+	spec   string
+	starts []uint16
+}
+
+func newBinQuantizer(spec string, starts []uint16) (*binQuantizer, error) {
+	if len(starts) == 0 || starts[0] != 0 {
+		return nil, fmt.Errorf("excord: invalid quantization %q: the first bin must start at 0", spec)
+	}
+	for i := 1; i < len(starts); i++ {
+		if starts[i] <= starts[i-1] {
+			return nil, fmt.Errorf("excord: invalid quantization %q: bin starts must be increasing", spec)
+		}
+	}
+	return &binQuantizer{spec: spec, starts: starts}, nil
+}
+
+func newLogQuantizer(steps int) *binQuantizer {
+	starts := []uint16{0}
+	for k := 0; ; k++ {
+		v := math.Round(math.Pow(2, float64(k)/float64(steps)))
+		if v > math.MaxUint16 {
+			break
+		}
+		if uint16(v) > starts[len(starts)-1] {
+			starts = append(starts, uint16(v))
+		}
+	}
+	return &binQuantizer{spec: fmt.Sprintf("log:%d", steps), starts: starts}
+}
+
+// bin returns the index of the bin containing v.
+func (q *binQuantizer) bin(v uint16) int {
+	return sort.Search(len(q.starts), func(i int) bool { return q.starts[i] > v }) - 1
+}
+
+func (q *binQuantizer) mid(i int) float64 {
+	if i == len(q.starts)-1 {
+		return float64(q.starts[i])
+	}
+	return (float64(q.starts[i]) + float64(q.starts[i+1]) - 1) / 2
+}
+
+func (q *binQuantizer) Quantize(v uint16) uint16 {
+	return uint16(q.mid(q.bin(v)))
+}
+
+func (q *binQuantizer) Dequantize(v uint16) float64 {
+	return q.mid(q.bin(v))
+}
+
+func (q *binQuantizer) Spec() string { return q.spec }
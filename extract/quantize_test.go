@@ -0,0 +1,134 @@
+package extract
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/brentp/mmslice/uint16mm"
+)
+
+func TestParseQuantizer(t *testing.T) {
+	for spec, want := range map[string]Quantizer{
+		"":       ladderQuantizer{},
+		"ladder": ladderQuantizer{},
+		"none":   noQuantizer{},
+		"log":    newLogQuantizer(4),
+		"log:1": &binQuantizer{spec: "log:1", starts: []uint16{0, 1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048,
+			4096, 8192, 16384, 32768}},
+		"0,1,2,4,8,16,32": &binQuantizer{spec: "0,1,2,4,8,16,32", starts: []uint16{0, 1, 2, 4, 8, 16, 32}},
+		"0, 10, 255":      &binQuantizer{spec: "0, 10, 255", starts: []uint16{0, 10, 255}},
+		"0,1000,65535":    &binQuantizer{spec: "0,1000,65535", starts: []uint16{0, 1000, 65535}},
+	} {
+		q, err := ParseQuantizer(spec)
+		if err != nil {
+			t.Fatalf("ParseQuantizer(%q): %v", spec, err)
+		}
+		if !reflect.DeepEqual(q, want) {
+			t.Errorf("ParseQuantizer(%q) = %+v, want %+v", spec, q, want)
+		}
+		// the spec that is stored in the metadata gives the same quantizer back.
+		if q2, err := ParseQuantizer(q.Spec()); err != nil || !reflect.DeepEqual(q2, q) {
+			t.Errorf("ParseQuantizer(%q) = %+v, %v, want %+v", q.Spec(), q2, err, q)
+		}
+	}
+
+	for _, spec := range []string{"log:", "log:0", "log:-2", "log:x", "1,2,4", "0,2,2", "0,4,2", "0,65536", "0,-1", "0,,2", "ladders"} {
+		if q, err := ParseQuantizer(spec); err == nil {
+			t.Errorf("ParseQuantizer(%q) = %+v, expected an error", spec, q)
+		}
+	}
+}
+
+func TestQuantize(t *testing.T) {
+	type pair struct{ in, out uint16 }
+	for spec, want := range map[string][]pair{
+		"none":   {{0, 0}, {7, 7}, {255, 255}, {300, 300}},
+		"ladder": {{0, 0}, {6, 6}, {7, 6}, {8, 8}, {17, 16}, {18, 18}, {20, 18}, {21, 23}, {47, 48}, {84, 77}, {255, 247}, {300, 255}},
+		"log:1":  {{0, 0}, {1, 1}, {2, 2}, {3, 2}, {4, 5}, {127, 95}, {128, 191}, {255, 191}, {300, 383}, {40000, 32768}},
+		"0,5,10": {{0, 2}, {4, 2}, {5, 7}, {9, 7}, {10, 10}, {255, 10}},
+	} {
+		q, err := ParseQuantizer(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range want {
+			if got := q.Quantize(p.in); got != p.out {
+				t.Errorf("%s: Quantize(%d) = %d, want %d", spec, p.in, got, p.out)
+			}
+		}
+	}
+
+	for _, spec := range []string{"none", "ladder", "log", "log:1", "log:8", "0,5,10,100"} {
+		q, _ := ParseQuantizer(spec)
+		bq, isBins := q.(*binQuantizer)
+		for v := uint16(0); v <= 300; v++ {
+			s := q.Quantize(v)
+			// stored values are stable, so tracks can be quantized again. the ladder predates
+			// this and moves a few values at the edges of its steps.
+			if s2 := q.Quantize(s); s2 != s && spec != DefaultQuantizer {
+				t.Fatalf("%s: Quantize(%d) = %d but Quantize(%d) = %d", spec, v, s, s, s2)
+			}
+			d := q.Dequantize(s)
+			if !isBins {
+				if d != float64(s) {
+					t.Fatalf("%s: Dequantize(%d) = %v", spec, s, d)
+				}
+				continue
+			}
+			// a depth is dequantized to the middle of its bin, or the last start above it.
+			i := bq.bin(v)
+			lo, hi := float64(bq.starts[i]), float64(bq.starts[i])
+			if i+1 < len(bq.starts) {
+				hi = float64(bq.starts[i+1] - 1)
+			}
+			if d != (lo+hi)/2 || math.Floor(d) != float64(s) {
+				t.Fatalf("%s: %d is stored as %d and dequantized to %v, want %v", spec, v, s, d, (lo+hi)/2)
+			}
+		}
+	}
+}
+
+func TestExcordQuantize(t *testing.T) {
+	const n = 10
+	for _, tc := range []struct {
+		spec       string
+		read, pair []uint16
+	}{
+		// the ladder keeps 2 and 3, which are in two alt windows, and caps depths at 255.
+		{"ladder", []uint16{3, 43, 83, 123, 162, 196, 247, 247, 247, 247}, []uint16{0, 1, 2, 3, 4, 5, 6, 6, 8, 8}},
+		// bins see depths above 255 and quantize the alt windows too.
+		{"0,4,16,64,256", []uint16{1, 39, 159, 159, 159, 159, 159, 256, 256, 256}, []uint16{1, 1, 1, 1, 9, 9, 9, 9, 9, 9}},
+	} {
+		ex := &excord{mask: newAltMask(n), meta: &CoverageMeta{Quantization: "none"}}
+		var err error
+		if ex.readCov, err = uint16mm.Open(nil, n); err != nil {
+			t.Fatal(err)
+		}
+		if ex.pairCov, err = uint16mm.Open(nil, n); err != nil {
+			t.Fatal(err)
+		}
+		for i := range ex.readCov.A {
+			ex.readCov.A[i] = uint16(3 + 40*i)
+			ex.pairCov.A[i] = uint16(i)
+		}
+		ex.readCov.A[8], ex.readCov.A[9] = 300, 1000
+		ex.mask.add(2, 4)
+		ex.mask.add(1, 4)
+
+		q, err := ParseQuantizer(tc.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ex.quantize(q)
+		if !ex.meta.Quantized || ex.meta.Quantization != tc.spec {
+			t.Fatalf("%s: quantizing gave metadata %+v", tc.spec, ex.meta)
+		}
+		if !reflect.DeepEqual(ex.readCov.A, tc.read) {
+			t.Errorf("%s: read coverage is %v, want %v", tc.spec, ex.readCov.A, tc.read)
+		}
+		if !reflect.DeepEqual(ex.pairCov.A, tc.pair) {
+			t.Errorf("%s: pair coverage is %v, want %v", tc.spec, ex.pairCov.A, tc.pair)
+		}
+	}
+}
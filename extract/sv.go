@@ -13,13 +13,14 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/Schaudge/ngsutils/insertsize"
+	"github.com/Schaudge/ngsutils/rename"
 	arg "github.com/alexflint/go-arg"
 	"github.com/biogo/hts/bam"
 	"github.com/biogo/hts/sam"
 	"github.com/brentp/bigly"
 	"github.com/brentp/bigly/bamat"
 	"github.com/brentp/faidx"
-	"github.com/brentp/goleft/covstats"
 	"github.com/brentp/mmslice/uint16mm"
 	"github.com/brentp/xopen"
 )
@@ -31,10 +32,18 @@ type cliarg struct {
 	ExcludeFlag        uint16  `arg:"-F"`
 	MinMappingQuality  uint8   `arg:"-Q"`
 	DiscordantDistance int     `arg:"-d,help:distance at which mates are considered discordant. if not provided it is calcuated from data"`
-	NoQuantize         bool    `arg:"-n,help:do not quantize reference depths (quantizing results in better compression)."`
+	NoQuantize         bool    `arg:"-n,help:do not quantize reference depths (quantizing results in better compression). same as -q none"`
+	Quantize           string  `arg:"-q,help:quantization scheme for reference depths. one of ladder (default) or none or log[:bins per doubling] or bin starts separated by commas"`
 	Fasta              string  `arg:"-f,help:path to fasta file. used to check for mismatches"`
-	BamPath            string  `arg:"positional,required"`
-	Region             string  `arg:"positional"`
+	InsertSizes        string  `arg:"-i,help:insert-size estimates written by the insert sub-command. used for the discordant distance if -d is not given"`
+	EstimatePairs      int     `arg:"--estimate-pairs,help:when reading from stdin without -d or -i estimate insert sizes from this many pairs at the start of the stream"`
+	Blacklist          string  `arg:"-x,help:BED of regions such as centromeres and repeats. discordants and splitters with an end in them are dropped"`
+	FlagBlacklisted    bool    `arg:"--flag-blacklisted,help:keep discordants and splitters in the -x regions and add a column with the ends in them (1 2 or 3) to every record"`
+	Breakpoints        string  `arg:"--breakpoints,help:pile up soft-clipped reads and write the junctions found from their consensus to this path. they are also added to the bedpe with type -2"`
+	Rename             string  `arg:"--rename,help:file mapping contig names without chr to the names to write in the bedpe"`
+	StrictRename       bool    `arg:"--strict-rename,help:fail on contigs that are not in --rename instead of warning"`
+	BamPath            string  `arg:"positional,required,help:indexed BAM. without a region the BAM is streamed from stdin and must be sorted by coordinate"`
+	Region             string  `arg:"positional,help:chromosome or region to extract"`
 	medianReadLength   float64 `arg:"-"`
 }
 
@@ -43,6 +52,8 @@ type pairType int
 const (
 	idiscordantSA = -1
 	idiscordant   = 0
+	// iclipConsensus is a junction found by joining the consensus of reads soft-clipped at each end.
+	iclipConsensus = -2
 )
 
 type bedPE struct {
@@ -60,17 +71,36 @@ type bedPE struct {
 type excord struct {
 	readCov            *uint16mm.Slice
 	pairCov            *uint16mm.Slice
-	altMask0           []bool
-	altMask1           []bool
+	mask               *altMask
 	discordantDistance int
-
-	chrom string
+	// insert has per-read-group insert-size estimates. if set, it decides which pairs are
+	// discordant, with discordantDistance used for reads without a read group.
+	insert *insertsize.Model
+	// blacklist drops or flags discordants and splitters. it is shared by the excords for each
+	// chromosome of a stream so the counts cover the whole run.
+	blacklist *blacklist
+	// clips collects soft-clipped reads for breakpoint refinement. it may be shared by the
+	// excords for each chromosome of a stream.
+	clips *clipPile
+	// renamer, if set, renames the contigs of the bedpe records as they are written.
+	renamer *rename.Map
+
+	chrom  string
+	prefix string
+	meta   *CoverageMeta
 
 	f  io.Writer
-	ch chan *bedPE
+	ch chan alt
 	wg *sync.WaitGroup
 }
 
+// alt is a record for the writer. excluded has the ends in the blacklist when they are flagged
+// rather than dropped.
+type alt struct {
+	*bedPE
+	excluded int8
+}
+
 func cap255(s []uint16) {
 	for i, v := range s {
 		if v == 255 {
@@ -113,17 +143,20 @@ func _quantize(val uint16) uint16 {
 	return val
 }
 
-func (ex *excord) quantize() {
-	if ex.altMask0 == nil {
+func (ex *excord) quantize(q Quantizer) {
+	if ex.mask == nil {
 		log.Println("not quantizing")
 		return
 	}
-	log.Println("quantizing")
+	log.Printf("quantizing with %s", q.Spec())
 	//radius := 7
 	ex.pairCov.Flush()
 	ex.readCov.Flush()
-	cap255(ex.pairCov.A)
-	cap255(ex.readCov.A)
+	depths := storesDepths(q)
+	if depths {
+		cap255(ex.pairCov.A)
+		cap255(ex.readCov.A)
+	}
 	ex.pairCov.Flush()
 	ex.readCov.Flush()
 
@@ -148,97 +181,98 @@ func (ex *excord) quantize() {
 
 	orc, opc := ex.readCov.A, ex.pairCov.A
 
-	for i, m := range ex.altMask1 {
-		// no quantization if we're in an alt region
-		if m {
+	for i := range orc {
+		// no quantization if we're in an alt region and the track stores depths.
+		if depths && ex.mask.Twice(i) {
 			continue
 		}
-		orc[i] = _quantize(rc.A[i])
-		opc[i] = _quantize(pc.A[i])
+		orc[i] = q.Quantize(rc.A[i])
+		opc[i] = q.Quantize(pc.A[i])
 	}
+	ex.meta.Quantized = true
+	ex.meta.Quantization = q.Spec()
 	log.Println("done quantizing")
 }
 
 func newExcord(chromLen int, prefix string, discordantDistance int, writeRef bool) *excord {
 	e := &excord{}
 	if writeRef {
-		rcov, err := uint16mm.Create(prefix+"read.bin", int64(chromLen))
+		rcov, err := uint16mm.Create(prefix+readCoverageFile, int64(chromLen))
 
 		pcheck(err)
-		pcov, err := uint16mm.Create(prefix+"pair.bin", int64(chromLen))
+		pcov, err := uint16mm.Create(prefix+pairCoverageFile, int64(chromLen))
 		pcheck(err)
-		e.altMask0 = make([]bool, int64(chromLen))
-		e.altMask1 = make([]bool, int64(chromLen))
+		e.prefix = prefix
+		e.meta = &CoverageMeta{Length: chromLen, DiscordantDistance: discordantDistance, Quantization: "none"}
+		e.mask = newAltMask(chromLen)
 		e.readCov = rcov
 		e.pairCov = pcov
 	}
-	e.ch = make(chan *bedPE, 5)
+	e.ch = make(chan alt, 5)
 	e.wg = &sync.WaitGroup{}
 	e.wg.Add(1)
 	e.discordantDistance = discordantDistance
 	e.f = bufio.NewWriter(os.Stdout)
 	go func() {
-		for b := range e.ch {
-			if _, err := fmt.Fprintf(e.f, "%s\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d\n", b.c1, b.s1, b.e1, b.strand1, b.c2, b.s2, b.e2, b.strand2, b.iType); err != nil {
+		for a := range e.ch {
+			b := a.bedPE
+			if _, err := fmt.Fprintf(e.f, "%s\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%d", e.contig(b.c1), b.s1, b.e1, b.strand1, e.contig(b.c2), b.s2, b.e2, b.strand2, b.iType); err != nil {
+				panic(err)
+			}
+			if e.blacklist != nil && e.blacklist.flag {
+				fmt.Fprintf(e.f, "\t%d", a.excluded)
+			}
+			if _, err := io.WriteString(e.f, "\n"); err != nil {
 				panic(err)
 			}
-			e.updateMask(b)
+			if a.excluded == 0 {
+				e.updateMask(b)
+			}
 		}
 		e.wg.Done()
 	}()
 	return e
 }
 
+// contig gives the name of chrom in the output. "-1" is the contig of a record without a second
+// end.
+func (ex *excord) contig(chrom string) string {
+	if ex.renamer == nil || chrom == "-1" {
+		return chrom
+	}
+	to, err := ex.renamer.Rename(chrom)
+	pcheck(err)
+	return to
+}
+
 func (ex *excord) updateMask(b *bedPE) {
 	if ex.discordantDistance == 0 {
 		return
 	}
-	mask0, mask1 := ex.altMask0, ex.altMask1
-	var s, e int
+	if b.c1 == ex.chrom {
+		ex.markAlt(b.altWindow(1, ex.discordantDistance))
+	}
+	if b.c2 == ex.chrom {
+		ex.markAlt(b.altWindow(2, ex.discordantDistance))
+	}
+}
+
+// altWindow gives the interval around end 1 or 2 of b in which the breakpoint it supports is
+// expected to be.
+func (b *bedPE) altWindow(end int, discordantDistance int) (s, e int) {
 	if b.iType == idiscordant || b.iType == idiscordantSA {
-		if b.c1 == ex.chrom {
+		// a discordant read points toward the breakpoint so we look up to the discordant
+		// distance past its end in the direction of its strand.
+		if end == 1 {
 			if b.strand1 == 1 {
-				s, e = b.e1-10, b.e1+ex.discordantDistance
-			} else {
-				s, e = b.s1-ex.discordantDistance, b.s1+10
-			}
-			if s < 0 {
-				s = 0
-			}
-			if e > len(mask0) {
-				e = len(mask0)
-			}
-			for i := s; i < e; i++ {
-				if mask0[i] {
-					mask1[i] = true
-				} else {
-					mask0[i] = true
-				}
+				return b.e1 - 10, b.e1 + discordantDistance
 			}
+			return b.s1 - discordantDistance, b.s1 + 10
 		}
-
-		if b.c2 == ex.chrom {
-			if b.strand2 == 1 {
-				s, e = b.e2-10, b.e2+ex.discordantDistance
-			} else {
-				s, e = b.s2-ex.discordantDistance, b.s2+10
-			}
-			if s < 0 {
-				s = 0
-			}
-			if e > len(mask0) {
-				e = len(mask0)
-			}
-			for i := s; i < e; i++ {
-				if mask0[i] {
-					mask1[i] = true
-				} else {
-					mask0[i] = true
-				}
-			}
+		if b.strand2 == 1 {
+			return b.e2 - 10, b.e2 + discordantDistance
 		}
-
-		return
+		return b.s2 - discordantDistance, b.s2 + 10
 	}
 	// ##################################
 	// splitter
@@ -248,38 +282,16 @@ func (ex *excord) updateMask(b *bedPE) {
 	// we know that c1:s1-e1 is the left end and c2:s2-e2 is the right
 	//  [xxxxxx]-----------------[xxxxxx]
 	//       *****             *****
-	if b.c1 == ex.chrom {
-		s, e := b.e1-10, b.e1+10
-		if s < 0 {
-			s = 0
-		}
-		if e > len(mask0) {
-			e = len(mask0)
-		}
-		for i := s; i < e; i++ {
-			if mask0[i] {
-				mask1[i] = true
-			} else {
-				mask0[i] = true
-			}
-		}
+	if end == 1 {
+		return b.e1 - 10, b.e1 + 10
 	}
+	return b.s2 - 10, b.s2 + 10
+}
 
-	if b.c2 == ex.chrom {
-		s, e := b.s2-10, b.s2+10
-		if s < 0 {
-			s = 0
-		}
-		if e > len(mask0) {
-			e = len(mask0)
-		}
-		for i := s; i < e; i++ {
-			if mask0[i] {
-				mask1[i] = true
-			} else {
-				mask0[i] = true
-			}
-		}
+// markAlt records that the bases in [s, e) have alternate support.
+func (ex *excord) markAlt(s, e int) {
+	if ex.mask != nil {
+		ex.mask.add(s, e)
 	}
 }
 
@@ -291,14 +303,54 @@ func (e *excord) Close() error {
 		b.Flush()
 	}
 	if e.readCov != nil {
+		// the metadata is written last so that an interrupted run leaves tracks that readers reject.
+		merr := e.writeMeta()
 		e.readCov.Close()
-		return e.pairCov.Close()
+		if err := e.pairCov.Close(); err != nil {
+			return err
+		}
+		return merr
 	}
 	return nil
 }
 
+// distance gives the discordant distance for the read group of r.
+func (ex *excord) distance(r *sam.Record) int {
+	if ex.insert == nil {
+		return ex.discordantDistance
+	}
+	if rg := insertsize.ReadGroup(r); rg != "" {
+		return ex.insert.Distance(rg)
+	}
+	return ex.discordantDistance
+}
+
 func (e *excord) WriteAlt(b *bedPE) {
-	e.ch <- b
+	e.ch <- alt{bedPE: b}
+}
+
+// writeEvidence writes a discordant, splitter or soft-clip junction record unless an end is in
+// the blacklist, in which case it is counted and dropped or flagged. It reports whether the record
+// was written.
+func (e *excord) writeEvidence(b *bedPE, splitter bool) bool {
+	a := alt{bedPE: b}
+	if e.blacklist != nil {
+		if a.excluded = e.blacklist.endsIn(b); a.excluded != 0 {
+			switch {
+			case b.iType == iclipConsensus:
+				e.blacklist.excludedJunction++
+			case splitter:
+				e.blacklist.excludedSplitter++
+			default:
+				e.blacklist.excludedDiscordant++
+			}
+			if !e.blacklist.flag {
+				return false
+			}
+		}
+	}
+	e.ch <- a
+	return true
 }
 
 func (e *excord) updatePairCoverage(start, end int) {
@@ -370,7 +422,7 @@ func writeSAs(chrom string, lefts, rights []*bigly.SA, ex *excord, discordantDis
 			}
 			if discordantSA(l, r, discordantDistance) {
 				b := bedPE{string(stripChr(l.Chrom)), l.Pos, l.End(), intStrand(l.Strand), string(stripChr(r.Chrom)), r.Pos, r.End(), intStrand(r.Strand), -1}
-				ex.WriteAlt(&b)
+				ex.writeEvidence(&b, false)
 			} else if cmp == 0 && bytes.Equal(r.Chrom, []byte(chrom)) {
 				refs = append(refs, &interval{r.Chrom, l.Pos, r.End()})
 
@@ -432,7 +484,7 @@ func writeDiscordant(r *sam.Record, ex *excord, opts *cliarg, m map[string][]*bi
 	if r.Start() == r.MatePos && r.Ref.ID() == r.MateRef.ID() {
 		if r.Flags&sam.MateUnmapped == sam.MateUnmapped {
 			// handle paired, but mate unmapped
-			ex.WriteAlt(&bedPE{sstripChr(r.Ref.Name()), r.Start(), r.End(), r.Strand(), "-1", -1, -1, 0, int(r.Flags)})
+			ex.writeEvidence(&bedPE{sstripChr(r.Ref.Name()), r.Start(), r.End(), r.Strand(), "-1", -1, -1, 0, int(r.Flags)}, false)
 		}
 		return
 	}
@@ -443,8 +495,8 @@ func writeDiscordant(r *sam.Record, ex *excord, opts *cliarg, m map[string][]*bi
 		if r.Ref.ID() == r.MateRef.ID() && r.Strand() == 1 && r.Flags&sam.MateReverse != 0 {
 			chr := sstripChr(r.Ref.Name())
 			b := bedPE{chr, r.MatePos, getMateEnd(r, opts), -1, chr, r.Start(), r.End(), 1, 0}
-			ex.WriteAlt(&b)
-		} else if r.Ref.ID() != r.MateRef.ID() || discordantByDistance(r, opts.DiscordantDistance) {
+			ex.writeEvidence(&b, false)
+		} else if r.Ref.ID() != r.MateRef.ID() || discordantByDistance(r, ex.distance(r)) {
 			start := r.Start()
 			mateStart, mateEnd := r.MatePos, getMateEnd(r, opts)
 			mateFlag := int8(1)
@@ -455,10 +507,10 @@ func writeDiscordant(r *sam.Record, ex *excord, opts *cliarg, m map[string][]*bi
 			// always output left-most mate first.
 			if chrom < mateChrom || chrom == mateChrom && start < mateStart {
 				b := bedPE{sstripChr(r.Ref.Name()), start, r.End(), r.Strand(), sstripChr(r.MateRef.Name()), mateStart, mateEnd, mateFlag, 0}
-				ex.WriteAlt(&b)
+				ex.writeEvidence(&b, false)
 			} else {
 				b := bedPE{sstripChr(r.MateRef.Name()), mateStart, mateEnd, mateFlag, sstripChr(r.Ref.Name()), start, r.End(), r.Strand(), 0}
-				ex.WriteAlt(&b)
+				ex.writeEvidence(&b, false)
 			}
 		}
 		// for each tag in this pair we see if it is
@@ -488,7 +540,7 @@ func writeDiscordant(r *sam.Record, ex *excord, opts *cliarg, m map[string][]*bi
 			}
 			bsas = []*bigly.SA{&bigly.SA{Chrom: []byte(r.MateRef.Name()), Pos: r.MatePos, Cigar: v[3:]}}
 		}
-		return writeSAs(r.Ref.Name(), asas, bsas, ex, opts.DiscordantDistance)
+		return writeSAs(r.Ref.Name(), asas, bsas, ex, ex.distance(r))
 	}
 	if r.MateRef.ID() != r.Ref.ID() {
 		return
@@ -510,7 +562,9 @@ func writeDiscordant(r *sam.Record, ex *excord, opts *cliarg, m map[string][]*bi
 // C:90S30M30S
 // we would order them as they are listed. We would output bedpe intervals
 // for A-B, and B-C
-func writeSplitter(r *sam.Record, ex *excord) int {
+// With a fasta, the breakpoints given by this read's own clipped ends are refined with recStart and
+// recEnd.
+func writeSplitter(r *sam.Record, ex *excord, fasta *faidx.Faidx) int {
 	if r.Flags&sam.Secondary != 0 && r.Flags&sam.Unmapped != 0 {
 		return 0
 	}
@@ -543,21 +597,24 @@ func writeSplitter(r *sam.Record, ex *excord) int {
 	// now we have tags sorted by the position in the read that they represent.
 	// given splits a, b, c, d we output a -> b, b -> c, c -> d where each ->
 	// represents evidence of splitter between those chunks.
-	for i, b := range tags {
-		if i == 0 {
-			continue
+	rs, re := recStart(r, fasta), recEnd(r, fasta)
+	span := func(t *bigly.SA) (int, int) {
+		if fasta != nil && t.Pos == r.Start() && bytes.Equal(t.Chrom, []byte(r.Ref.Name())) && bigly.FirstMatch(t.Parsed) == bigly.FirstMatch(r.Cigar) {
+			return rs, re
 		}
+		return t.Pos, t.End()
+	}
+	for i := 1; i < len(tags); i++ {
 		// the last column indicates number of SA fields. Is 0 for discordants above.
-		a := tags[i-1]
+		a, b := &tags[i-1], &tags[i]
+		as, ae := span(a)
+		bs, be := span(b)
 		cmp := bytes.Compare(a.Chrom, b.Chrom)
 		// always output the left-most first.
 		if cmp < 0 || cmp == 0 && a.Pos < b.Pos {
-			b := bedPE{string(stripChr(a.Chrom)), a.Pos, a.End(), intStrand(a.Strand), string(stripChr(b.Chrom)), b.Pos, b.End(), intStrand(b.Strand), len(tags) - 1}
-			ex.WriteAlt(&b)
+			ex.writeEvidence(&bedPE{string(stripChr(a.Chrom)), as, ae, intStrand(a.Strand), string(stripChr(b.Chrom)), bs, be, intStrand(b.Strand), len(tags) - 1}, true)
 		} else {
-			b := bedPE{string(stripChr(b.Chrom)), b.Pos, b.End(), intStrand(b.Strand), string(stripChr(a.Chrom)), a.Pos, a.End(), intStrand(a.Strand), len(tags) - 1}
-			ex.WriteAlt(&b)
-
+			ex.writeEvidence(&bedPE{string(stripChr(b.Chrom)), bs, be, intStrand(b.Strand), string(stripChr(a.Chrom)), as, ae, intStrand(a.Strand), len(tags) - 1}, true)
 		}
 	}
 	return len(tags)
@@ -568,41 +625,123 @@ func discordantByDistance(r *sam.Record, discordantDistance int) bool {
 	return d > discordantDistance
 }
 
+// maxClipScan is how far from a soft-clip recEnd and recStart look for mismatches.
+const maxClipScan = 20
+
+// refWindow gets [start, end) of chrom from the fasta, adding or removing a "chr" prefix if the
+// fasta names chromosomes differently.
+func refWindow(f *faidx.Faidx, chrom string, start, end int) (string, error) {
+	seq, err := f.Get(chrom, start, end)
+	if err == nil {
+		return seq, nil
+	}
+	if strings.HasPrefix(chrom, "chr") {
+		return f.Get(chrom[3:], start, end)
+	}
+	return f.Get("chr"+chrom, start, end)
+}
+
+// clippedMismatches compares the aligned bases next to the soft-clip at the end (or start) of r
+// to the reference. Aligners extend an alignment through mismatches before clipping it, so the
+// true boundary is found by trimming the run of bases next to the clip that has the largest
+// excess of mismatches over matches. It returns the number of bases to trim or -1 if r is not
+// soft-clipped on that side or the reference can't be read.
+func clippedMismatches(r *sam.Record, f *faidx.Faidx, atEnd bool) int {
+	cig := r.Cigar
+	i, step := 0, 1
+	if atEnd {
+		i, step = len(cig)-1, -1
+	}
+	for i >= 0 && i < len(cig) && cig[i].Type() == sam.CigarHardClipped {
+		i += step
+	}
+	if i < 0 || i >= len(cig) || cig[i].Type() != sam.CigarSoftClipped {
+		return -1
+	}
+	clip := cig[i].Len()
+	i += step
+	if i < 0 || i >= len(cig) {
+		return -1
+	}
+	switch cig[i].Type() {
+	case sam.CigarMatch, sam.CigarEqual, sam.CigarMismatch:
+	default:
+		return -1
+	}
+	n := min(cig[i].Len(), maxClipScan)
+	seq := r.Seq.Expand()
+	// q and p are the query and reference offsets of the n aligned bases next to the clip.
+	q, p := clip, r.Start()
+	if atEnd {
+		q, p = len(seq)-clip-n, r.End()-n
+	}
+	if q < 0 {
+		return -1
+	}
+	ref, err := refWindow(f, r.Ref.Name(), p, p+n)
+	if err != nil || len(ref) != n {
+		return -1
+	}
+	best, trim, score := 0, 0, 0
+	for k := 1; k <= n; k++ {
+		j := k - 1
+		if atEnd {
+			j = n - k
+		}
+		rb, qb := ref[j]&^0x20, seq[q+j]&^0x20
+		switch {
+		case rb == 'N' || qb == 'N':
+		case rb == qb:
+			score--
+		default:
+			score++
+		}
+		if score > best {
+			best, trim = score, k
+		}
+	}
+	return trim
+}
+
+// recEnd gives the end of the alignment of r. If r ends in a soft-clip, mismatches next to the
+// clip are not counted as aligned: they are found by comparing to the reference if f is given,
+// otherwise all NM mismatches are assumed to be next to the clip.
 func recEnd(r *sam.Record, f *faidx.Faidx) int {
+	if f != nil {
+		if t := clippedMismatches(r, f, true); t >= 0 {
+			return r.End() - t
+		}
+	}
 	if r.Cigar[len(r.Cigar)-1].Type() == sam.CigarSoftClipped {
 		if nm, ok := r.Tag([]byte{'N', 'M'}); ok {
 			v := nm[3]
 			if v == 0 {
 				return r.End()
 			}
-			/*
-				seq := r.Seq.Expand()
-				l := len(seq)
-				chrom := r.Ref.Name()
-				e := r.End()
-				o := r.Cigar[len(r.Cigar)-1].Len()
-				log.Println(r.Start(), r.End(), o, bigly.RefPieces(r.Pos, r.Cigar), bigly.ReadPieces(r.Cigar), r.Cigar, len(seq))
-				for i := 1; i < l-1-o; i++ {
-					refbase, err := f.At(chrom, e-i)
-					log.Println(refbase, seq[l-i-o])
-					if err != nil {
-						log.Fatalf("error accessing %s:%d [%s]", chrom, e-i, err)
-					}
-
-				}
-			*/
 			return r.End() - int(v)
 		}
 	}
 	return r.End()
 }
 
+// recStart is like recEnd for a soft-clip at the start of r. It only trims mismatches when f
+// is given.
+func recStart(r *sam.Record, f *faidx.Faidx) int {
+	if f != nil {
+		if t := clippedMismatches(r, f, false); t >= 0 {
+			return r.Start() + t
+		}
+	}
+	return r.Start()
+}
+
 // writeReferenceCoverage updates the coverage arrays (mmap'ed) for the fragment that that
 // current record represents. "reference" is in the context of SVs. Reads without splitters
 // or softclips are evidence for reference. The insert between reads with an insert size within
 // 2SDs of the mean is also evidence for reference.
 // If the pairs are discordant, it returns false if the interval is shorter than minAlignSize
-func writeReferenceCoverage(r *sam.Record, fasta *faidx.Faidx, ex *excord, discordantDistance int) bool {
+// Whether the pair is discordant uses the distance for the read group of r.
+func writeReferenceCoverage(r *sam.Record, fasta *faidx.Faidx, ex *excord) bool {
 	ses := bigly.RefPieces(r.Pos, r.Cigar)
 	n := 0
 	for i := 0; i < len(ses); i += 2 {
@@ -623,7 +762,7 @@ func writeReferenceCoverage(r *sam.Record, fasta *faidx.Faidx, ex *excord, disco
 		return true
 	}
 
-	if discordantByDistance(r, discordantDistance) {
+	if discordantByDistance(r, ex.distance(r)) {
 		return true
 	}
 
@@ -636,23 +775,202 @@ func writeReferenceCoverage(r *sam.Record, fasta *faidx.Faidx, ex *excord, disco
 	return true
 }
 
-func stdinMain(cli *cliarg) int {
-	if cli.Prefix != "" {
-		panic("excord: cant specify prefix without region")
+// newChromExcord returns an excord for chrom. If prefix is not empty, it writes coverage tracks
+// for the chromosome with that prefix.
+func newChromExcord(cli *cliarg, chrom string, cLen int, prefix string, model *insertsize.Model) *excord {
+	if prefix == "" {
+		ex := newExcord(cLen, prefix, cli.DiscordantDistance, false)
+		ex.insert = model
+		return ex
+	}
+	ex := newExcord(cLen, prefix, cli.DiscordantDistance, true)
+	ex.insert = model
+	ex.chrom = sstripChr(chrom)
+	ex.meta.Program = cli.Version()
+	ex.meta.Chrom = ex.chrom
+	ex.meta.Region = cli.Region
+	ex.meta.ExcludeFlag = cli.ExcludeFlag
+	ex.meta.MinMappingQuality = cli.MinMappingQuality
+	return ex
+}
+
+// process handles a single record. It is shared by the region and stdin modes so that they give
+// the same output.
+func (ex *excord) process(b *sam.Record, cli *cliarg, fasta *faidx.Faidx, m map[string][]*bigly.SA) {
+	if uint16(b.Flags)&cli.ExcludeFlag != 0 {
+		return
+	}
+	if b.MapQ < cli.MinMappingQuality {
+		return
+	}
+	writeSplitter(b, ex, fasta)
+	if ex.clips != nil {
+		ex.clips.add(b, fasta)
+	}
+	refs := writeDiscordant(b, ex, cli, m)
+	if ex.readCov == nil {
+		return
 	}
+	if refs != nil {
+		writeRefIntervals(refs, ex)
+	}
+	writeReferenceCoverage(b, fasta, ex)
+}
+
+// loadBlacklist reads the regions given with -x or returns nil.
+func (c *cliarg) loadBlacklist() *blacklist {
+	if c.Blacklist == "" {
+		return nil
+	}
+	bl, err := readBlacklist(c.Blacklist, c.FlagBlacklisted)
+	pcheck(err)
+	return bl
+}
+
+// renameMap reads the map given with --rename or returns nil.
+func (c *cliarg) renameMap() *rename.Map {
+	if c.Rename == "" {
+		return nil
+	}
+	mode := rename.Warn
+	if c.StrictRename {
+		mode = rename.Strict
+	}
+	m, err := rename.ReadMap(c.Rename, "", "", mode)
+	pcheck(err)
+	return m
+}
+
+// clipPile returns a pile for soft-clipped reads if --breakpoints was given.
+func (c *cliarg) clipPile() *clipPile {
+	if c.Breakpoints == "" {
+		return nil
+	}
+	return newClipPile()
+}
+
+// finish waits for all alts to be written and then quantizes the coverage tracks. Close must
+// still be called.
+func (ex *excord) finish(q Quantizer) {
+	close(ex.ch)
+	ex.wg.Wait()
+	if ex.mask == nil {
+		return
+	}
+	cLen := ex.mask.Len()
+	s := ex.mask.CountTwice()
+	log.Printf("bases covered by <= 1 alt: %d, out of: %d -> %.4f%%", s, cLen, 100-100*float64(s)/float64(cLen))
+	s = ex.mask.CountOnce()
+	log.Printf("bases covered by < 0 alt: %d, out of: %d -> %.4f%%", s, cLen, 100-100*float64(s)/float64(cLen))
+	log.Printf("alt mask used %.1fMB", float64(ex.mask.Bytes())/1e6)
+	if q.Spec() != "none" {
+		ex.quantize(q)
+	}
+}
+
+// quantizer returns the scheme selected by -q and -n.
+func (c *cliarg) quantizer() Quantizer {
+	if c.NoQuantize {
+		c.Quantize = "none"
+	}
+	q, err := ParseQuantizer(c.Quantize)
+	pcheck(err)
+	return q
+}
+
+func (c *cliarg) fasta() *faidx.Faidx {
+	if c.Fasta == "" {
+		return nil
+	}
+	fasta, err := faidx.New(c.Fasta)
+	pcheck(err)
+	return fasta
+}
+
+func logDistances(model *insertsize.Model) {
+	log.Printf("using distance: %d", model.Distance(""))
+	for rg, e := range model.ReadGroups {
+		log.Printf("using distance: %d for read group %s", e.Distance, rg)
+	}
+}
+
+// estimateFromStream estimates insert sizes from the first pairs in br. It returns the records
+// that it read so they can be processed.
+func estimateFromStream(br *bam.Reader, pairs int) ([]*sam.Record, *insertsize.Model, error) {
+	sampler := insertsize.NewSampler(pairs)
+	var recs []*sam.Record
+	for {
+		b, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		recs = append(recs, b)
+		if !sampler.Add(b) {
+			break
+		}
+	}
+	log.Printf("estimated insert sizes from %d pairs in %d records", sampler.Pairs(), len(recs))
+	model, err := sampler.Model(insertsize.Cutoff{})
+	return recs, model, err
+}
+
+// stdinMain handles a BAM streamed to stdin, which must be sorted by coordinate. With a prefix,
+// coverage tracks are written for each chromosome as the stream reaches it, with the chromosome
+// added to the prefix. A chromosome that comes before the last one is an error, since its tracks
+// and those of the chromosomes after it would already have been written.
+func stdinMain(cli *cliarg) int {
 	if !xopen.IsStdin() {
 		panic("excord: utils streamed to sdin when no region is specified")
 	}
+	q := cli.quantizer()
+	br, err := bam.NewReader(bufio.NewReader(os.Stdin), 3)
+	pcheck(err)
+	log.Printf("found %d chromosomes in header", len(br.Header().Refs()))
+
+	var model *insertsize.Model
+	var recs []*sam.Record
 	if cli.DiscordantDistance == 0 {
-		panic("excord: when reading from stdin, you must provide a discordant distance")
+		if cli.InsertSizes != "" {
+			model, err = insertsize.Load(cli.InsertSizes)
+		} else {
+			recs, model, err = estimateFromStream(br, cli.EstimatePairs)
+		}
+		pcheck(err)
+		cli.DiscordantDistance = model.Distance("")
+		logDistances(model)
 	}
-
-	ex := newExcord(0, cli.Prefix, cli.DiscordantDistance, false)
-	defer ex.Close()
+	fasta := cli.fasta()
 
 	m := make(map[string][]*bigly.SA, 1e5)
-	br, err := bam.NewReader(bufio.NewReader(os.Stdin), 3)
-	pcheck(err)
+	clips, bl, renamer := cli.clipPile(), cli.loadBlacklist(), cli.renameMap()
+	ex := newChromExcord(cli, "", 0, "", model)
+	ex.clips, ex.blacklist, ex.renamer = clips, bl, renamer
+	refID, refName := -1, ""
+	handle := func(b *sam.Record) error {
+		if b.Ref != nil && b.Ref.ID() != refID {
+			// a chromosome seen before sorts before the current one in the header.
+			if b.Ref.ID() < refID {
+				return fmt.Errorf("excord: input must be sorted by coordinate: found %s:%d after %s", b.Ref.Name(), b.Pos+1, refName)
+			}
+			refID, refName = b.Ref.ID(), b.Ref.Name()
+			if cli.Prefix != "" {
+				ex.finish(q)
+				pcheck(ex.Close())
+				log.Printf("starting coverage for %s", refName)
+				ex = newChromExcord(cli, refName, b.Ref.Len(), cli.Prefix+sstripChr(refName)+".", model)
+				ex.clips, ex.blacklist, ex.renamer = clips, bl, renamer
+			}
+		}
+		ex.process(b, cli, fasta, m)
+		return nil
+	}
+	for _, b := range recs {
+		pcheck(handle(b))
+	}
+	recs = nil
 	for {
 		b, err := br.Read()
 		if err == io.EOF {
@@ -661,30 +979,35 @@ func stdinMain(cli *cliarg) int {
 		if err != nil {
 			panic(err)
 		}
-		if uint16(b.Flags)&cli.ExcludeFlag != 0 {
-			continue
-		}
-		if b.MapQ < cli.MinMappingQuality {
-			continue
-		}
-		writeSplitter(b, ex)
-		writeDiscordant(b, ex, cli, m)
+		pcheck(handle(b))
+	}
+	if clips != nil {
+		// junctions are only known at the end, so those on earlier chromosomes aren't in
+		// their alt masks.
+		writeClipJunctions(clips, ex, cli.Breakpoints)
+	}
+	ex.finish(q)
+	pcheck(ex.Close())
+	if bl != nil {
+		bl.log()
 	}
-	close(ex.ch)
 
 	return 0
 }
 
 func SvReads() {
 	cli := &cliarg{ExcludeFlag: uint16(sam.Unmapped | sam.QCFail | sam.Duplicate),
-		MinMappingQuality: 1}
+		MinMappingQuality: 1, EstimatePairs: 1e5}
 	arg.MustParse(cli)
 	log.Println(cli.Region, cli.BamPath)
+	cli.Prefix = normalizePrefix(cli.Prefix)
 
 	if cli.Region == "" {
 		os.Exit(stdinMain(cli))
 	}
 
+	q := cli.quantizer()
+
 	chromse := strings.Split(cli.Region, ":")
 	b, err := bamat.New(cli.BamPath)
 	pcheck(err)
@@ -699,33 +1022,24 @@ func SvReads() {
 		panic(fmt.Sprintf("didn't find chromosome: %s\n", chrom))
 	}
 
+	// per-read-group distances are only used when the distance is not given.
+	var model *insertsize.Model
 	if cli.DiscordantDistance == 0 {
-		stats := covstats.BamStats(b.Reader, 5e5)
-		b.Reader.Omit(0)
-		cli.DiscordantDistance = int(stats.TemplateMean + 5*stats.TemplateSD)
-		log.Printf("using distance: %d", cli.DiscordantDistance)
-	}
-
-	if cli.Prefix != "" && !strings.HasSuffix(cli.Prefix, "/") && !strings.HasSuffix(cli.Prefix, ".") {
-		cli.Prefix += "."
-	}
-
-	var ex *excord
-
-	if cli.Prefix != "" {
-		ex = newExcord(cLen, cli.Prefix, cli.DiscordantDistance, true)
-		ex.chrom = sstripChr(chrom)
-	} else {
-		ex = newExcord(cLen, cli.Prefix, cli.DiscordantDistance, false)
+		if cli.InsertSizes != "" {
+			model, err = insertsize.Load(cli.InsertSizes)
+		} else {
+			model, err = insertsize.FromReader(b.Reader, 5e5, insertsize.Cutoff{})
+		}
+		pcheck(err)
+		cli.DiscordantDistance = model.Distance("")
+		logDistances(model)
 	}
-	defer ex.Close()
 
-	var fasta *faidx.Faidx
+	ex := newChromExcord(cli, chrom, cLen, cli.Prefix, model)
+	ex.clips, ex.blacklist, ex.renamer = cli.clipPile(), cli.loadBlacklist(), cli.renameMap()
+	defer func() { pcheck(ex.Close()) }()
 
-	if cli.Fasta != "" {
-		fasta, err = faidx.New(cli.Fasta)
-		pcheck(err)
-	}
+	fasta := cli.fasta()
 
 	var start, end int
 	if len(chromse) > 1 {
@@ -753,42 +1067,15 @@ func SvReads() {
 	m := make(map[string][]*bigly.SA, 1e5)
 
 	for it.Next() {
-		b := it.Record()
-		if uint16(b.Flags)&cli.ExcludeFlag != 0 {
-			continue
-		}
-		if b.MapQ < cli.MinMappingQuality {
-			continue
-		}
-		writeSplitter(b, ex)
-		refs := writeDiscordant(b, ex, cli, m)
-		if refs != nil && ex != nil {
-			writeRefIntervals(refs, ex)
-		}
-		if ex != nil {
-			writeReferenceCoverage(b, fasta, ex, cli.DiscordantDistance)
-		}
+		ex.process(it.Record(), cli, fasta, m)
 	}
 	pcheck(it.Error())
-	close(ex.ch)
-	ex.wg.Wait()
-	s := 0
-	for _, v := range ex.altMask1 {
-		if v {
-			s++
-		}
+	if ex.clips != nil {
+		writeClipJunctions(ex.clips, ex, cli.Breakpoints)
 	}
-	log.Printf("bases covered by <= 1 alt: %d, out of: %d -> %.4f%%", s, cLen, 100-100*float64(s)/float64(cLen))
-	s = 0
-	for _, v := range ex.altMask0 {
-		if v {
-			s++
-		}
-	}
-
-	log.Printf("bases covered by < 0 alt: %d, out of: %d -> %.4f%%", s, cLen, 100-100*float64(s)/float64(cLen))
-	if !cli.NoQuantize {
-		ex.quantize()
+	ex.finish(q)
+	if ex.blacklist != nil {
+		ex.blacklist.log()
 	}
 }
 
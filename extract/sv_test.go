@@ -0,0 +1,115 @@
+package extract
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/faidx"
+)
+
+// refSeq is the sequence of chromosome 1 in testdata/ref.fa.
+const refSeq = "GCTAAAGACAATTACATAACATACACGTCAGCACGAAACTTGTTGGCCCAGTGTGAATCG" +
+	"CTTAAGGGTTAAGTAAGTGTGATGCATACGCCTTTACTTGCTGTGTCCACCCCATCGGAC"
+
+func openTestFasta(t *testing.T) *faidx.Faidx {
+	t.Helper()
+	f, err := faidx.New("testdata/ref.fa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+// mismatch returns a base that differs from b.
+func mismatch(b byte) byte {
+	if b == 'A' {
+		return 'C'
+	}
+	return 'A'
+}
+
+// withMismatches returns the reference in [start, end) with the bases at the given offsets
+// replaced by mismatches.
+func withMismatches(start, end int, offsets ...int) string {
+	s := []byte(refSeq[start:end])
+	for _, o := range offsets {
+		s[o] = mismatch(s[o])
+	}
+	return string(s)
+}
+
+func newTestRecord(t *testing.T, chrom string, pos int, cigar string, seq string, aux ...sam.Aux) *sam.Record {
+	t.Helper()
+	ref, err := sam.NewReference(chrom, "", "", len(refSeq), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	cig, err := sam.ParseCigar([]byte(cigar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sam.NewRecord("r", ref, ref, pos, -1, 0, 60, cig, []byte(seq), nil, aux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRecEnd(t *testing.T) {
+	fasta := openTestFasta(t)
+	clip := strings.Repeat("T", 10)
+	cases := []struct {
+		name  string
+		chrom string
+		cigar string
+		seq   string
+		want  int
+	}{
+		{"exact", "1", "30M10S", refSeq[20:50] + clip, 50},
+		{"two mismatches at clip", "1", "30M10S", withMismatches(20, 50, 28, 29) + clip, 48},
+		{"mismatch then match at clip", "1", "30M10S", withMismatches(20, 50, 26, 27, 28) + clip, 46},
+		{"isolated mismatch away from clip", "1", "30M10S", withMismatches(20, 50, 25) + clip, 50},
+		{"not clipped", "1", "30M", withMismatches(20, 50, 28, 29), 50},
+		{"hard clip after soft clip", "1", "30M10S5H", withMismatches(20, 50, 29) + clip, 49},
+		{"chr prefix not in fasta", "chr1", "30M10S", withMismatches(20, 50, 29) + clip, 49},
+	}
+	for _, c := range cases {
+		r := newTestRecord(t, c.chrom, 20, c.cigar, c.seq)
+		if got := recEnd(r, fasta); got != c.want {
+			t.Errorf("%s: got end %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestRecStart(t *testing.T) {
+	fasta := openTestFasta(t)
+	clip := strings.Repeat("T", 10)
+	r := newTestRecord(t, "1", 40, "10S30M", clip+withMismatches(40, 70, 0, 1, 2))
+	if got := recStart(r, fasta); got != 43 {
+		t.Errorf("got start %d, want 43", got)
+	}
+	r = newTestRecord(t, "1", 40, "10S30M", clip+refSeq[40:70])
+	if got := recStart(r, fasta); got != 40 {
+		t.Errorf("got start %d, want 40", got)
+	}
+	// without a fasta the start isn't changed.
+	r = newTestRecord(t, "1", 40, "10S30M", clip+withMismatches(40, 70, 0))
+	if got := recStart(r, nil); got != 40 {
+		t.Errorf("got start %d, want 40", got)
+	}
+}
+
+func TestRecEndWithoutFasta(t *testing.T) {
+	nm, err := sam.NewAux(sam.NewTag("NM"), uint8(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := newTestRecord(t, "1", 20, "30M10S", withMismatches(20, 50, 29)+strings.Repeat("T", 10), nm)
+	if got := recEnd(r, nil); got != 47 {
+		t.Errorf("got end %d, want 47 from NM", got)
+	}
+}
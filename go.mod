@@ -3,8 +3,17 @@ module github.com/Schaudge/ngsutils
 go 1.18
 
 require (
- 
-  github.com/biogo/hts v1.4.3
-  github.com/go-sql-driver/mysql v1.6.0
+	github.com/alexflint/go-arg v1.6.1
+	github.com/biogo/hts v1.4.3
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/klauspost/compress v1.15.15
+	github.com/pkg/errors v0.9.1
+	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c
+	gopkg.in/yaml.v3 v3.0.1
+)
 
+require (
+	github.com/alexflint/go-scalar v1.2.0 // indirect
+	github.com/kr/pretty v0.2.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
 )
@@ -8,15 +8,14 @@
 // and just return the Atoi of the 2nd column.
 //
 // Header lines are assumed to start with '#'. To indicate other lines that are header lines, the
-// user function to Sort() can return `[]int{gsort.HEADER_LINE}`.
+// user function to Sort() can return `[]int{gsort.HEADER_LINE}`. Such lines, and comment lines
+// with Options.CommentPrefix, are written after the '#' header, in the order of the input.
 package gsort
 
 import (
 	"bufio"
 	"bytes"
-	"compress/flate"
 	"fmt"
-	"io/ioutil"
 	"math"
 	"os/signal"
 	"path/filepath"
@@ -24,22 +23,23 @@ import (
 	"syscall"
 	"time"
 
-	gzip "github.com/klauspost/compress/gzip"
 	"github.com/pkg/errors"
 
-	//gzip "github.com/klauspost/pgzip"
-
 	"container/heap"
 	"io"
 	"log"
 	"os"
 	"sort"
+	"sync"
 )
 
 type chunk struct {
 	lines [][]byte
 	idxs  []int // used only in Heap
 	Cols  [][]int
+	// stable breaks ties in the Heap by the index of the file, which is the order of the chunks
+	// in the input.
+	stable bool
 }
 
 func (c chunk) Len() int {
@@ -54,6 +54,9 @@ func (c chunk) Less(i, j int) bool {
 		}
 		return c.Cols[i][k] < c.Cols[j][k]
 	}
+	if c.stable && len(c.idxs) > 0 {
+		return c.idxs[i] < c.idxs[j]
+	}
 	return false
 }
 func (c *chunk) Swap(i, j int) {
@@ -101,8 +104,25 @@ func (c *chunk) Pop() interface{} {
 // Processor is a function that takes a line and return a slice of ints that determine ordering
 type Processor func(line []byte) []int
 
-// Sort accepts a tab-delimited io.Reader and writes to wtr using prepocess to determine ordering
+// Sort accepts a tab-delimited io.Reader and writes to wtr using prepocess to determine ordering.
+// Errors reading rdr, writing temporary files or writing wtr are returned, and the temporary
+// files are removed before it returns.
 func Sort(rdr io.Reader, wtr io.Writer, preprocess Processor, memMB int, chromosomeMappings map[string]string) error {
+	return SortWithOptions(rdr, wtr, preprocess, &Options{MemMB: memMB, ChromosomeMappings: chromosomeMappings})
+}
+
+// SortWithOptions is Sort with the memory, temporary directories and compression of the
+// temporary files set by opts. A nil opts uses the defaults. The output starts with the
+// opts.SkipLines first lines, then the '#' lines at the start of rdr, then the lines that
+// preprocess marks with HEADER_LINE and the comment lines, each in the order of the input, and
+// then the sorted lines.
+func SortWithOptions(rdr io.Reader, wtr io.Writer, preprocess Processor, opts *Options) error {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if _, err := ParseCodec(string(opts.Codec)); err != nil {
+		return err
+	}
 
 	/*
 		f, perr := os.Create("gsort.pprof")
@@ -114,94 +134,213 @@ func Sort(rdr io.Reader, wtr io.Writer, preprocess Processor, memMB int, chromos
 	*/
 
 	brdr, bwtr := bufio.NewReader(rdr), bufio.NewWriter(wtr)
-	defer bwtr.Flush()
+
+	for i := 0; i < opts.SkipLines; i++ {
+		line, err := brdr.ReadBytes('\n')
+		if _, werr := bwtr.Write(line); werr != nil {
+			return errors.Wrap(werr, "error writing skipped lines")
+		}
+		if err == io.EOF {
+			return errors.Wrap(bwtr.Flush(), "error writing skipped lines")
+		}
+		if err != nil {
+			return errors.Wrap(err, "error reading skipped lines")
+		}
+	}
 
 	if err := writeHeader(bwtr, brdr); err == io.EOF {
-		return nil
+		return errors.Wrap(bwtr.Flush(), "error writing header")
 	} else if err != nil {
 		return errors.Wrap(err, "error reading/writing header")
 	}
 
-	ch := make(chan [][]byte)
-	go readLines(ch, brdr, memMB, chromosomeMappings)
-	fileNames := writeChunks(ch, preprocess)
-
-	for _, f := range fileNames {
-		defer os.Remove(f)
+	ch := make(chan lineChunk)
+	// done stops the reader if the chunks can't be written.
+	done := make(chan struct{})
+	var readErr error
+	budget := newChunkBudget(opts.chunkMemory())
+	go func() {
+		readErr = readLines(ch, done, brdr, preprocess, opts, budget)
+		close(ch)
+	}()
+	fileNames, header, sorted, err := writeChunks(ch, opts, budget)
+	defer func() {
+		for _, f := range fileNames {
+			os.Remove(f)
+		}
+	}()
+	if err != nil {
+		close(done)
+		// the reader may be waiting for the memory of the chunks it sent.
+		for c := range ch {
+			budget.release(c.mem)
+		}
+		return err
+	}
+	// ch is closed, so readErr is set.
+	if readErr != nil {
+		return errors.Wrap(readErr, "error reading lines")
+	}
+	for _, line := range header {
+		if _, err := bwtr.Write(line); err != nil {
+			return errors.Wrap(err, "error writing header")
+		}
 	}
 
-	if len(fileNames) == 1 {
-		return writeOne(fileNames[0], bwtr)
+	if sorted {
+		// the input was already sorted, so the chunks are written out in turn.
+		for _, f := range fileNames {
+			if err = writeOne(f, bwtr, opts); err != nil {
+				break
+			}
+		}
+	} else if len(fileNames) == 1 {
+		err = writeOne(fileNames[0], bwtr, opts)
+	} else {
+		err = merge(fileNames, bwtr, preprocess, opts)
 	}
-	// TODO have special merge for when stuff is already mostly sorted. don't need pri queue.
-	return merge(fileNames, bwtr, preprocess)
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(bwtr.Flush(), "error writing output")
 }
 
-func readLines(ch chan [][]byte, rdr *bufio.Reader, memMb int, chromosomeMappings map[string]string) {
-
-	mem := int(1000000.0 * float64(memMb) * 0.7)
-
-	lines := make([][]byte, 0, 500000)
+// readLines sends chunks of lines from rdr until it reaches the end of rdr or done is closed. Each
+// chunk is sent when its estimated memory reaches its share of what budget has free or, in
+// adaptive mode, when the measured heap is over budget. The first chunk may use all of the budget,
+// so that small inputs are sorted in one chunk; later chunks use half of what is free, which
+// leaves the rest for the next chunk while the workers write this one, and a new chunk waits
+// until enough is free. process is called once for each line and its key is sent with the line.
+// Until a line is out of order, each key is compared to the one before it so that chunks of sorted
+// input are marked as sorted. Lines marked with HEADER_LINE and comment lines are moved to the
+// header of their chunk in the order that they are read.
+func readLines(ch chan lineChunk, done chan struct{}, rdr *bufio.Reader, process Processor, opts *Options, budget *chunkBudget) error {
+
+	renamer := opts.renamer()
+
+	var lines [][]byte
+	var keys [][]int
 	var line []byte
 	var err error
 
-	sum := 0
+	// sum is the estimated memory of lines, hsum that of header, and keyInts the length of the
+	// keys from process, which is found from the first line.
+	sum, hsum, keyInts := 0, 0, -1
 	k := 0
+	read := 0
+	// prev is the key of the last line while the input is in order. The keys are only needed to
+	// sort a chunk once a line is out of order, but that may be part way through the chunk.
+	inOrder := true
+	var prev []int
+
+	// header has the header and comment lines of the chunk.
+	var header [][]byte
+	comment := []byte(opts.CommentPrefix)
+
+	send := func() bool {
+		budget.take(sum, hsum)
+		select {
+		case ch <- lineChunk{lines: lines, keys: keys, header: header, sorted: inOrder, mem: sum}:
+			header = nil
+			return true
+		case <-done:
+			return false
+		}
+	}
+	// limit is the memory at which the chunk is full. It grows as the workers finish chunks.
+	limit := func() int {
+		free := budget.free()
+		if k > 0 {
+			free /= 2
+		}
+		if free < minChunkMemory {
+			free = minChunkMemory
+		}
+		return free
+	}
 
 	for {
 
 		line, err = rdr.ReadBytes('\n')
 		if err != nil && err != io.EOF {
-			log.Fatal(err)
+			return err
+		}
+		if n := len(line); n > 0 && line[n-1] != '\n' {
+			line = append(line, '\n')
 		}
 
-		if len(line) > 0 {
-			if chromosomeMappings != nil {
-				i := bytes.IndexRune(line, '\t')
-				chrom := string(line[0:i])
-
-				newChrom, ok := chromosomeMappings[chrom]
-				if !ok {
-					log.Printf("[gsort] WARNING: could not find mapping for chromosome: %s", chrom)
-				} else {
-					line = append([]byte(newChrom), line[i:]...)
+		if len(line) > 0 && len(comment) > 0 && bytes.HasPrefix(line, comment) {
+			header = append(header, line)
+			hsum += lineMemory(len(line), 0)
+		} else if len(line) > 0 {
+			if renamer != nil {
+				var rerr error
+				if line, rerr = renamer.Line(line); rerr != nil {
+					return rerr
 				}
 			}
 
-			lines = append(lines, line)
-			sum += len(line)
+			key := process(line)
+			if isHeaderKey(key) {
+				header = append(header, line)
+				hsum += lineMemory(len(line), 0)
+			} else {
+				if keyInts < 0 {
+					keyInts = len(key)
+				}
+				if inOrder {
+					if prev != nil && compareKeys(key, prev) < 0 {
+						inOrder, prev = false, nil
+					} else {
+						prev = key
+					}
+				}
+				cl, ck := cap(lines), cap(keys)
+				lines = append(lines, line)
+				keys = append(keys, key)
+				sum += lineMemory(len(line), keyInts) + sliceHeader*(cap(lines)-cl+cap(keys)-ck)
+			}
+			read += len(line)
 		}
 
 		if len(line) == 0 || err == io.EOF {
-			np := len(lines)
-			last := lines[np-1]
-			if len(last) == 0 || last[len(last)-1] != '\n' {
-				lines[np-1] = append(last, '\n')
+			if len(lines) > 0 || len(header) > 0 {
+				send()
 			}
-			ch <- lines
-			break
+			return nil
 		}
 
-		if sum >= mem {
-			ch <- lines
-			lines = make([][]byte, 0, 500000)
-			if k == 0 {
-				ch <- make([][]byte, 0, 0)
-				mem /= 3
+		full := sum+hsum >= limit()
+		if opts.AdaptiveMemory && read >= adaptiveEvery {
+			read = 0
+			full = full || opts.overBudget()
+		}
+		if full {
+			if !send() {
+				return nil
 			}
+			lines, keys = nil, nil
 			k++
-			sum = 0
+			sum, hsum = 0, 0
+			budget.wait()
 		}
 	}
-	close(ch)
 }
 
 // indicate that this is a header line, even if it doesn't have '#' prefix
 const HEADER_LINE = math.MinInt32
 
+// isHeaderKey reports whether a Processor gave HEADER_LINE for a line.
+func isHeaderKey(key []int) bool {
+	return len(key) > 0 && key[0] == HEADER_LINE
+}
+
 func writeHeader(wtr *bufio.Writer, rdr *bufio.Reader) error {
 	for {
 		b, err := rdr.Peek(1)
+		if err == io.EOF {
+			return err
+		}
 		if err != nil {
 			return errors.Wrap(err, "error peaking for header")
 		}
@@ -209,96 +348,134 @@ func writeHeader(wtr *bufio.Writer, rdr *bufio.Reader) error {
 			break
 		}
 		line, err := rdr.ReadBytes('\n')
+		if _, werr := wtr.Write(line); werr != nil {
+			return werr
+		}
 		if err != nil {
 			return err
 		}
-		wtr.Write(line)
 	}
 	return nil
 }
 
 // fast path where we don't use merge if it all fit in memory.
-func writeOne(fname string, wtr io.Writer) error {
+func writeOne(fname string, wtr io.Writer, opts *Options) error {
 	rdr, err := os.Open(fname)
 	if err != nil {
 		return err
 	}
 	defer rdr.Close()
-	gz, err := gzip.NewReader(rdr)
+	cr, err := opts.newChunkReader(rdr)
 	if err == io.EOF {
 		return nil
 	}
 	if err != nil {
 		return err
 	}
-	_, err = io.Copy(wtr, gz)
+	defer cr.Close()
+	_, err = io.Copy(wtr, cr)
 	return errors.Wrapf(err, "error copying from %s", fname)
 }
 
-func merge(fileNames []string, wtr io.Writer, process Processor) error {
+func merge(fileNames []string, wtr io.Writer, process Processor, opts *Options) error {
 
 	start := time.Now()
 
 	fhs := make([]*bufio.Reader, len(fileNames))
-
-	cache := chunk{lines: make([][]byte, len(fileNames)),
-		Cols: make([][]int, len(fileNames)),
-		idxs: make([]int, len(fileNames))}
-
 	for i, fn := range fileNames {
 		fh, err := os.Open(fn)
 		if err != nil {
 			return errors.Wrap(err, fmt.Sprintf("error opening: %s", fn))
 		}
 		defer fh.Close()
-		//gz, err := newFastGzReader(fh)
-		gz, err := gzip.NewReader(fh)
+		cr, err := opts.newChunkReader(fh)
 		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("error reading %s as gzip", fn))
-		}
-		defer gz.Close()
-		fhs[i] = bufio.NewReader(gz)
-
-		line, err := fhs[i].ReadBytes('\n')
-		if len(line) > 0 {
-			cache.lines[i] = line
-			cache.Cols[i] = process(line)
-			cache.idxs[i] = i
-		} else if err == io.EOF {
-			continue
-		} else if err != nil {
-			return err
+			return errors.Wrap(err, fmt.Sprintf("error reading %s as %s", fn, opts.codec()))
 		}
+		defer cr.Close()
+		fhs[i] = bufio.NewReader(cr)
 	}
 
-	heap.Init(&cache)
+	err := mergeReaders(fhs, wtr, process, opts.Stable, false, func(i int) { os.Remove(fileNames[i]) })
+	if err != nil {
+		return err
+	}
 
-	for {
-		o := heap.Pop(&cache)
+	log.Printf("time to merge %d files: %.3f", len(fileNames), time.Since(start).Seconds())
+	return nil
+}
 
-		if o == nil {
-			break
-		}
-		c := o.(pair)
-		// refill from same file
-		line, err := fhs[c.idx].ReadBytes('\n')
+// mergeReaders merges the sorted lines of rdrs into wtr with a heap that holds a line from each
+// reader. With stable, lines with equal keys are written in the order of rdrs. With check, a line
+// that sorts before the one before it in the same reader is an error. exhausted, if not nil, is
+// called with the index of each reader when it is at the end.
+func mergeReaders(rdrs []*bufio.Reader, wtr io.Writer, process Processor, stable, check bool, exhausted func(i int)) error {
+	cache := chunk{lines: make([][]byte, 0, len(rdrs)),
+		Cols:   make([][]int, 0, len(rdrs)),
+		idxs:   make([]int, 0, len(rdrs)),
+		stable: stable}
+	lineNos := make([]int, len(rdrs))
+
+	// next pushes the next line of the ith reader, which follows a line with the key prev.
+	next := func(i int, prev []int) error {
+		line, err := rdrs[i].ReadBytes('\n')
 		if err != io.EOF && err != nil {
-			return err
+			return errors.Wrapf(err, "error reading input %d", i)
 		}
-		if len(line) != 0 {
-			next := pair{line: line, idx: c.idx, cols: process(line)}
-			heap.Push(&cache, next)
-		} else {
-			os.Remove(fileNames[c.idx])
+		if len(line) == 0 {
+			if exhausted != nil {
+				exhausted(i)
+			}
+			return nil
+		}
+		if line[len(line)-1] != '\n' {
+			line = append(line, '\n')
+		}
+		lineNos[i]++
+		cols := process(line)
+		if isHeaderKey(cols) && prev != nil {
+			// a marked line keeps its place after the line before it.
+			cols = prev
 		}
-		wtr.Write(c.line)
+		if check && prev != nil && compareKeys(cols, prev) < 0 {
+			return fmt.Errorf("gsort: input %d is not sorted at line %d", i, lineNos[i])
+		}
+		heap.Push(&cache, pair{line: line, idx: i, cols: cols})
+		return nil
+	}
 
+	for i := range rdrs {
+		if err := next(i, nil); err != nil {
+			return err
+		}
 	}
 
-	log.Printf("time to merge %d files: %.3f", len(fileNames), time.Since(start).Seconds())
+	for cache.Len() > 0 {
+		c := heap.Pop(&cache).(pair)
+		// refill from same reader
+		if err := next(c.idx, c.cols); err != nil {
+			return err
+		}
+		if _, err := wtr.Write(c.line); err != nil {
+			return errors.Wrap(err, "error writing merged lines")
+		}
+	}
 	return nil
 }
 
+// compareKeys compares the keys of two lines from a Processor.
+func compareKeys(a, b []int) int {
+	for k := 0; k < len(a) && k < len(b); k++ {
+		if a[k] != b[k] {
+			if a[k] < b[k] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(a) - len(b)
+}
+
 func init() {
 	// make sure we don't leave any temporary files.
 	c := make(chan os.Signal, 1)
@@ -309,52 +486,146 @@ func init() {
 		syscall.SIGQUIT)
 	go func() {
 		<-c
-		matches, err := filepath.Glob(filepath.Join(os.TempDir(), fmt.Sprintf("gsort.%d.*", pid)))
-		if err != nil {
-			log.Fatal(err)
-		}
-		for _, m := range matches {
-			os.Remove(m)
-		}
+		usedTempDirs.Store(os.TempDir(), true)
+		usedTempDirs.Range(func(dir, _ interface{}) bool {
+			matches, err := filepath.Glob(filepath.Join(dir.(string), fmt.Sprintf("gsort.%d.*", pid)))
+			if err != nil {
+				log.Println(err)
+			}
+			for _, m := range matches {
+				os.Remove(m)
+			}
+			return true
+		})
 		os.Exit(3)
 	}()
 
 }
 
-func writeChunks(ch chan [][]byte, process Processor) []string {
-	fileNames := make([]string, 0, 20)
-	pid := os.Getpid()
-	for lines := range ch {
-		if len(lines) == 0 {
+// lineChunk is a chunk of lines from readLines. keys has the key of each line from the Processor.
+// header has the lines of the chunk that are written before the sorted lines, in the order of the
+// input. sorted is set if the lines and all of the lines before them are in order.
+type lineChunk struct {
+	lines  [][]byte
+	keys   [][]int
+	header [][]byte
+	sorted bool
+	// mem is the estimated memory of lines, which is released from the budget once they are
+	// written.
+	mem int
+}
+
+// writeChunks sorts each chunk of lines from ch and writes it to a compressed temporary file,
+// using opts.Workers goroutines, and releases the memory of its lines from budget. It returns the names of the files that it created in the order of the chunks, which the caller
+// must remove, even if there is an error, the header lines of the chunks in order, and whether
+// all of the chunks were already sorted.
+func writeChunks(ch chan lineChunk, opts *Options, budget *chunkBudget) ([]string, [][]byte, bool, error) {
+	type job struct {
+		n int
+		lineChunk
+	}
+	jobs := make(chan job)
+	var (
+		mu        sync.Mutex
+		fileNames = make([]string, 0, 20)
+		headers   = make([][][]byte, 0, 20)
+		firstErr  error
+		workers   sync.WaitGroup
+	)
+	for w := 0; w < opts.workers(); w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for j := range jobs {
+				name, err := writeSortedChunk(j.n, j.lines, j.keys, j.sorted, opts)
+				budget.release(j.mem)
+				mu.Lock()
+				fileNames[j.n] = name
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	n, sorted := 0, true
+	for c := range ch {
+		sorted = sorted && c.sorted
+		mu.Lock()
+		failed := firstErr != nil
+		fileNames = append(fileNames, "")
+		headers = append(headers, c.header)
+		mu.Unlock()
+		if failed || len(c.lines) == 0 {
+			budget.release(c.mem)
+			if failed {
+				break
+			}
+			n++
 			continue
 		}
-		f, err := ioutil.TempFile("", fmt.Sprintf("gsort.%d.%d.", pid, len(fileNames)))
-		if err != nil {
-			log.Fatal(err)
+		jobs <- job{n, c}
+		n++
+	}
+	close(jobs)
+	workers.Wait()
+	runtime.GC()
+
+	names := fileNames[:0]
+	for _, name := range fileNames {
+		if name != "" {
+			names = append(names, name)
 		}
-		achunk := chunk{lines: lines, Cols: make([][]int, len(lines))}
-		for i, line := range achunk.lines {
-			achunk.Cols[i] = process(line)
+	}
+	var header [][]byte
+	for _, h := range headers {
+		header = append(header, h...)
+	}
+	return names, header, sorted, firstErr
+}
+
+// writeSortedChunk sorts lines by their keys, unless they are already sorted, and writes them to
+// the nth temporary file. It returns the name of the file if it was created.
+func writeSortedChunk(n int, lines [][]byte, keys [][]int, sorted bool, opts *Options) (string, error) {
+	f, err := opts.tempFile(n)
+	if err != nil {
+		return "", err
+	}
+	if !sorted {
+		achunk := chunk{lines: lines, Cols: keys}
+		if opts.Stable {
+			sort.Stable(&achunk)
+		} else {
+			sort.Sort(&achunk)
 		}
-		//lines = nil
+	}
 
-		//sort.Stable(&achunk)
-		sort.Sort(&achunk)
+	if err := writeChunk(f, lines, opts); err != nil {
+		f.Close()
+		return f.Name(), errors.Wrapf(err, "error writing temporary file %s", f.Name())
+	}
+	if err := f.Close(); err != nil {
+		return f.Name(), errors.Wrapf(err, "error closing temporary file %s", f.Name())
+	}
+	return f.Name(), nil
+}
 
-		gz, _ := gzip.NewWriterLevel(f, flate.BestSpeed)
-		wtr := bufio.NewWriterSize(gz, 65536)
-		for i, line := range achunk.lines {
-			wtr.Write(line)
-			achunk.lines[i] = nil
-			lines[i] = nil
+// writeChunk writes the sorted lines to f, releasing each line as it is written.
+func writeChunk(f io.Writer, lines [][]byte, opts *Options) error {
+	cw, err := opts.newChunkWriter(f)
+	if err != nil {
+		return err
+	}
+	wtr := bufio.NewWriterSize(cw, 65536)
+	for i, line := range lines {
+		if _, err := wtr.Write(line); err != nil {
+			return err
 		}
-		wtr.Flush()
-		achunk.Cols, lines = nil, nil
-		achunk.lines = nil
-		gz.Close()
-		f.Close()
-		fileNames = append(fileNames, f.Name())
+		lines[i] = nil
 	}
-	runtime.GC()
-	return fileNames
+	if err := wtr.Flush(); err != nil {
+		return err
+	}
+	return cw.Close()
 }
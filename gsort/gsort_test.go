@@ -2,13 +2,27 @@ package gsort_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"testing/quick"
+	"time"
 
-	"github.com/brentp/gsort"
+	"github.com/Schaudge/ngsutils/gsort"
+	"github.com/Schaudge/ngsutils/rename"
 
 	. "gopkg.in/check.v1"
 )
@@ -134,3 +148,658 @@ a	1
 `)
 
 }
+
+func (s *GSortTest) TestNaturalKey(c *C) {
+	names := []string{"chr10", "chrX", "chr2", "chr1_random", "chr1", "HLA-A*01:01", "chrUn_gl000220", "10", "2",
+		"scaffold_100000000", "scaffold_99999999", "chrUn_KI270302v1", "chrUn_KI270302v2"}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := make([]int, gsort.ContigKeyWidth), make([]int, gsort.ContigKeyWidth)
+		(*gsort.ContigOrder)(nil).Key(a, []byte(names[i]))
+		(*gsort.ContigOrder)(nil).Key(b, []byte(names[j]))
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+	c.Assert(names, DeepEquals, []string{"2", "10", "HLA-A*01:01", "chr1", "chr1_random", "chr2", "chr10",
+		"chrUn_KI270302v1", "chrUn_KI270302v2", "chrUn_gl000220", "chrX", "scaffold_99999999", "scaffold_100000000"})
+}
+
+func sortPreset(c *C, name string, order *gsort.ContigOrder, data string) string {
+	var wtr bytes.Buffer
+	err := gsort.Sort(strings.NewReader(data), &wtr, gsort.Presets[name].Processor(order), 22, nil)
+	c.Assert(err, IsNil)
+	return wtr.String()
+}
+
+func (s *GSortTest) TestBEDPreset(c *C) {
+	data := "#header\nchr10\t5\t6\nchr2\t10\t20\nchrM\t1\t2\nchr2\t10\t15\nchr1\t100\t200\n"
+	c.Assert(sortPreset(c, "bed", nil, data), Equals,
+		"#header\nchr1\t100\t200\nchr2\t10\t15\nchr2\t10\t20\nchr10\t5\t6\nchrM\t1\t2\n")
+
+	order := gsort.NewContigOrder([]string{"chrM", "chr2"})
+	c.Assert(sortPreset(c, "bed", order, data), Equals,
+		"#header\nchrM\t1\t2\nchr2\t10\t15\nchr2\t10\t20\nchr1\t100\t200\nchr10\t5\t6\n")
+}
+
+func (s *GSortTest) TestBEDPEPreset(c *C) {
+	data := "2\t1\t2\t3\t5\t6\n1\t8\t9\t2\t5\t6\n2\t1\t2\t1\t5\t6\n"
+	c.Assert(sortPreset(c, "bedpe", nil, data), Equals, "1\t8\t9\t2\t5\t6\n2\t1\t2\t1\t5\t6\n2\t1\t2\t3\t5\t6\n")
+}
+
+func (s *GSortTest) TestVCFPreset(c *C) {
+	header := "##fileformat=VCFv4.2\n##contig=<ID=Y,length=10>\n##contig=<ID=X,length=10>\n#CHROM\tPOS\tID\tREF\tALT\n"
+	order := gsort.ContigOrderFromHeader(bytesLines(header))
+	c.Assert(order.Len(), Equals, 2)
+	data := "X\t5\t.\tA\tT\nY\t9\t.\tA\tT\nX\t5\t.\tAC\tA\n1\t1\t.\tA\tT"
+	c.Assert(sortPreset(c, "vcf", order, header+data), Equals,
+		header+"Y\t9\t.\tA\tT\nX\t5\t.\tA\tT\nX\t5\t.\tAC\tA\n1\t1\t.\tA\tT\n")
+}
+
+func (s *GSortTest) TestGFFAndSAMPresets(c *C) {
+	gff := "1\ts\texon\t100\t200\t.\t+\t.\tID=e\n1\ts\tgene\t100\t900\t.\t+\t.\tID=g\n1\ts\tmRNA\t100\t800\t.\t+\t.\tID=t\n"
+	c.Assert(sortPreset(c, "gff", nil, gff), Equals,
+		"1\ts\tgene\t100\t900\t.\t+\t.\tID=g\n1\ts\tmRNA\t100\t800\t.\t+\t.\tID=t\n1\ts\texon\t100\t200\t.\t+\t.\tID=e\n")
+
+	sam := "r1\t4\t*\t0\nr2\t0\tchr2\t50\nr3\t0\tchr1\t70\nr4\t0\tchr2\t10\n"
+	order := gsort.ContigOrderFromHeader(bytesLines("@HD\tVN:1.6\n@SQ\tSN:chr2\tLN:100\n@SQ\tSN:chr1\tLN:100\n"))
+	c.Assert(sortPreset(c, "sam", order, sam), Equals, "r4\t0\tchr2\t10\nr2\t0\tchr2\t50\nr3\t0\tchr1\t70\nr1\t4\t*\t0\n")
+}
+
+func (s *GSortTest) TestPresetFromPath(c *C) {
+	for path, name := range map[string]string{"a.bed.gz": "bed", "x.GFF3": "gff", "y.vcf.bgz": "vcf", "z.bedpe": "bedpe"} {
+		p, ok := gsort.PresetFromPath(path)
+		c.Assert(ok, Equals, true)
+		c.Assert(p.Name, Equals, name)
+	}
+	_, ok := gsort.PresetFromPath("reads.bam")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *GSortTest) TestReadContigOrder(c *C) {
+	dir := c.MkDir()
+	fai := filepath.Join(dir, "ref.fa.fai")
+	c.Assert(os.WriteFile(fai, []byte("chr3\t100\t5\t60\t61\nchr1\t200\t200\t60\t61\n"), 0644), IsNil)
+	order, err := gsort.ReadContigOrder(fai)
+	c.Assert(err, IsNil)
+	c.Assert(sortPreset(c, "bed", order, "chr1\t1\t2\nchr3\t5\t6\n"), Equals, "chr3\t5\t6\nchr1\t1\t2\n")
+
+	vcf := filepath.Join(dir, "h.vcf")
+	c.Assert(os.WriteFile(vcf, []byte("##contig=<ID=chr1,length=200>\n#CHROM\n"), 0644), IsNil)
+	order, err = gsort.ReadContigOrder(vcf)
+	c.Assert(err, IsNil)
+	c.Assert(order.Len(), Equals, 1)
+}
+
+func bytesLines(s string) [][]byte {
+	var lines [][]byte
+	for _, l := range strings.SplitAfter(s, "\n") {
+		if l != "" {
+			lines = append(lines, []byte(l))
+		}
+	}
+	return lines
+}
+
+// failingReader returns err after the data of r.
+type failingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, f.err
+	}
+	return n, err
+}
+
+// failingWriter fails once more than n bytes are written.
+type failingWriter struct {
+	n int
+}
+
+func (f *failingWriter) Write(p []byte) (int, error) {
+	if f.n -= len(p); f.n < 0 {
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func bedLines(n int) string {
+	var b strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "chr%d\t%d\t%d\tsome-name-to-make-the-line-longer\n", i%23, (i*7919)%100000, (i*7919)%100000+10)
+	}
+	return b.String()
+}
+
+// wideBEDLines is bedLines with a long name, so that a few thousand lines are more than the 1MB
+// that a chunk gets at least and are spilled.
+func wideBEDLines(n int) string {
+	return strings.ReplaceAll(bedLines(n), "some-name-to-make-the-line-longer", strings.Repeat("wide-name-", 40))
+}
+
+func tempFiles(c *C) []string {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), fmt.Sprintf("gsort.%d.*", os.Getpid())))
+	c.Assert(err, IsNil)
+	return matches
+}
+
+func (s *GSortTest) TestSortErrors(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	data := bedLines(40000)
+
+	err := gsort.Sort(&failingReader{strings.NewReader(data), errors.New("read failed")}, ioutil.Discard, bed, 1, nil)
+	c.Assert(err, ErrorMatches, ".*read failed")
+	c.Assert(tempFiles(c), HasLen, 0)
+
+	err = gsort.Sort(strings.NewReader(data), &failingWriter{n: 100000}, bed, 1, nil)
+	c.Assert(err, ErrorMatches, ".*disk full")
+	c.Assert(tempFiles(c), HasLen, 0)
+
+	err = gsort.Sort(strings.NewReader("#h\n"+data[:1000]), &failingWriter{n: 10}, bed, 1, nil)
+	c.Assert(err, ErrorMatches, ".*disk full")
+
+	var wtr bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader(data), &wtr, bed, 1, nil), IsNil)
+	c.Assert(wtr.Len(), Equals, len(data))
+	c.Assert(tempFiles(c), HasLen, 0)
+}
+
+func (s *GSortTest) TestSortWithOptions(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	data := bedLines(30000)
+	var want bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader(data), &want, bed, 1, nil), IsNil)
+
+	dirs := []string{c.MkDir(), c.MkDir()}
+	for _, codec := range []gsort.Codec{gsort.CodecNone, gsort.CodecGzip, gsort.CodecZstd, gsort.CodecS2} {
+		for _, level := range []int{0, 3} {
+			var got bytes.Buffer
+			opts := &gsort.Options{MemMB: 1, TempDirs: dirs, Codec: codec, Level: level}
+			c.Assert(gsort.SortWithOptions(strings.NewReader(data), &got, bed, opts), IsNil)
+			c.Assert(got.String() == want.String(), Equals, true, Commentf("codec %s level %d", codec, level))
+		}
+	}
+	for _, d := range dirs {
+		left, err := filepath.Glob(filepath.Join(d, "gsort.*"))
+		c.Assert(err, IsNil)
+		c.Assert(left, HasLen, 0)
+	}
+
+	err := gsort.SortWithOptions(strings.NewReader(data), ioutil.Discard, bed, &gsort.Options{Codec: "lz5"})
+	c.Assert(err, ErrorMatches, ".*unknown codec.*")
+	err = gsort.SortWithOptions(strings.NewReader(data), ioutil.Discard, bed, &gsort.Options{MemMB: 1, TempDirs: []string{filepath.Join(dirs[0], "missing")}})
+	c.Assert(err, ErrorMatches, ".*temporary file.*")
+}
+
+func (s *GSortTest) TestSortWorkers(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	data := bedLines(50000)
+	var want bytes.Buffer
+	c.Assert(gsort.SortWithOptions(strings.NewReader(data), &want, bed, &gsort.Options{MemMB: 1, Workers: 1}), IsNil)
+	for _, workers := range []int{2, 3, 8} {
+		var got bytes.Buffer
+		c.Assert(gsort.SortWithOptions(strings.NewReader(data), &got, bed, &gsort.Options{MemMB: 1, Workers: workers}), IsNil)
+		c.Assert(got.String() == want.String(), Equals, true, Commentf("%d workers", workers))
+	}
+	err := gsort.SortWithOptions(strings.NewReader(data), ioutil.Discard, bed, &gsort.Options{MemMB: 1, Workers: 4, TempDirs: []string{filepath.Join(c.MkDir(), "missing")}})
+	c.Assert(err, NotNil)
+	c.Assert(tempFiles(c), HasLen, 0)
+}
+
+// benchBEDPE is the input for BenchmarkSortBEDPE. Its size in MB is GSORT_BENCH_MB (default 64),
+// which can be set to a few thousand to compare on a multi-GB file.
+var benchBEDPE []byte
+
+func bedpeData(b *testing.B) []byte {
+	if benchBEDPE != nil {
+		return benchBEDPE
+	}
+	size := 64
+	if v, err := strconv.Atoi(os.Getenv("GSORT_BENCH_MB")); err == nil {
+		size = v
+	}
+	var buf bytes.Buffer
+	rng := rand.New(rand.NewSource(1))
+	for buf.Len() < size<<20 {
+		s1, s2 := rng.Intn(250000000), rng.Intn(250000000)
+		fmt.Fprintf(&buf, "chr%d\t%d\t%d\tchr%d\t%d\t%d\tsv%d\t%d\t+\t-\n",
+			1+rng.Intn(22), s1, s1+rng.Intn(500), 1+rng.Intn(22), s2, s2+rng.Intn(500), rng.Int(), rng.Intn(60))
+	}
+	benchBEDPE = buf.Bytes()
+	return benchBEDPE
+}
+
+// BenchmarkSortBEDPE compares one worker, which sorts and writes the chunks one at a time as gsort
+// used to, with a pool of workers.
+func BenchmarkSortBEDPE(b *testing.B) {
+	data := bedpeData(b)
+	bedpe := gsort.Presets["bedpe"].Processor(nil)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				opts := &gsort.Options{MemMB: 64, Workers: workers}
+				if err := gsort.SortWithOptions(bytes.NewReader(data), ioutil.Discard, bedpe, opts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// tiedBED gives BED lines where many lines share a key and the last column records their input
+// order, and the lines after a stable sort.
+func tiedBED() (input string, sorted []string) {
+	var b strings.Builder
+	var lines []string
+	for i := 0; i < 40000; i++ {
+		l := fmt.Sprintf("chr%d\t%d\t%d\t%08d\n", i%3, (i*31)%50, (i*31)%50+1, i)
+		lines = append(lines, l)
+		b.WriteString(l)
+	}
+	key := gsort.Presets["bed"].Processor(nil)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return keyLess(key([]byte(lines[i])), key([]byte(lines[j])))
+	})
+	return b.String(), lines
+}
+
+func keyLess(a, b []int) bool {
+	for k := range a {
+		if a[k] != b[k] {
+			return a[k] < b[k]
+		}
+	}
+	return false
+}
+
+func (s *GSortTest) TestSortStable(c *C) {
+	input, lines := tiedBED()
+	key := gsort.Presets["bed"].Processor(nil)
+	want := strings.Join(lines, "")
+	for _, workers := range []int{1, 3} {
+		var got bytes.Buffer
+		opts := &gsort.Options{MemMB: 1, Workers: workers, Stable: true}
+		c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, key, opts), IsNil)
+		c.Assert(got.String() == want, Equals, true, Commentf("%d workers", workers))
+	}
+}
+
+func (s *GSortTest) TestSortSorted(c *C) {
+	// sorted input is written as it is, even where an unstable sort could reorder ties.
+	_, lines := tiedBED()
+	key := gsort.Presets["bed"].Processor(nil)
+	want := "#header\n" + strings.Join(lines, "")
+	for _, workers := range []int{1, 3} {
+		var got bytes.Buffer
+		opts := &gsort.Options{MemMB: 1, Workers: workers}
+		c.Assert(gsort.SortWithOptions(strings.NewReader(want), &got, key, opts), IsNil)
+		c.Assert(got.String() == want, Equals, true, Commentf("%d workers", workers))
+	}
+
+	// input that is only out of order at the end is still sorted.
+	input := want + "chr0\t1\t2\tlast\n"
+	var got bytes.Buffer
+	c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, key, &gsort.Options{MemMB: 1}), IsNil)
+	out := bytesLines(got.String())
+	c.Assert(len(out), Equals, len(lines)+2)
+	for i := 2; i < len(out); i++ {
+		c.Assert(keyLess(key(out[i]), key(out[i-1])), Equals, false, Commentf("line %d", i))
+	}
+}
+
+func (s *GSortTest) TestMergeSorted(c *C) {
+	_, lines := tiedBED()
+	key := gsort.Presets["bed"].Processor(nil)
+	// each contig is sorted on its own, and lines with equal keys are in one input.
+	parts := make([]strings.Builder, 3)
+	for i := range parts {
+		fmt.Fprintf(&parts[i], "#header %d\n", i)
+	}
+	for _, l := range lines {
+		parts[l[3]-'0'].WriteString(l)
+	}
+	rdrs := []io.Reader{strings.NewReader(parts[2].String()), strings.NewReader(""), strings.NewReader(parts[0].String()), strings.NewReader(parts[1].String())}
+	var got bytes.Buffer
+	c.Assert(gsort.MergeSorted(rdrs, &got, key), IsNil)
+	c.Assert(got.String() == "#header 2\n"+strings.Join(lines, ""), Equals, true)
+
+	// ties go to the inputs in order and a missing newline at the end is added.
+	got.Reset()
+	rdrs = []io.Reader{strings.NewReader("chr1\t5\t6\tb\nchr2\t1\t2\tb"), strings.NewReader("chr1\t1\t2\ta\nchr1\t5\t6\ta\n")}
+	c.Assert(gsort.MergeSorted(rdrs, &got, key), IsNil)
+	c.Assert(got.String(), Equals, "chr1\t1\t2\ta\nchr1\t5\t6\tb\nchr1\t5\t6\ta\nchr2\t1\t2\tb\n")
+
+	// marked lines are kept in place after the line before them in their input.
+	marked := func(line []byte) []int {
+		if bytes.HasPrefix(line, []byte("track")) {
+			return []int{gsort.HEADER_LINE}
+		}
+		return key(line)
+	}
+	got.Reset()
+	rdrs = []io.Reader{strings.NewReader("#h\ntrack a\nchr1\t5\t6\tb\ntrack b\nchr2\t1\t2\tb\n"), strings.NewReader("chr1\t1\t2\ta\ntrack c\nchr1\t7\t8\ta\n")}
+	c.Assert(gsort.MergeSorted(rdrs, &got, marked), IsNil)
+	c.Assert(got.String(), Equals, "#h\ntrack a\nchr1\t1\t2\ta\ntrack c\nchr1\t5\t6\tb\ntrack b\nchr1\t7\t8\ta\nchr2\t1\t2\tb\n")
+
+	rdrs = []io.Reader{strings.NewReader("chr1\t1\t2\n"), strings.NewReader("chr1\t5\t6\nchr1\t1\t2\n")}
+	err := gsort.MergeSorted(rdrs, ioutil.Discard, key)
+	c.Assert(err, ErrorMatches, ".*input 1 is not sorted at line 2.*")
+
+	// files may be gzipped.
+	dir := c.MkDir()
+	plain, gz := filepath.Join(dir, "a.bed"), filepath.Join(dir, "b.bed.gz")
+	c.Assert(ioutil.WriteFile(plain, []byte(parts[0].String()), 0644), IsNil)
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	zw.Write([]byte(parts[1].String() + parts[2].String()[len("#header 2\n"):]))
+	c.Assert(zw.Close(), IsNil)
+	c.Assert(ioutil.WriteFile(gz, zb.Bytes(), 0644), IsNil)
+	got.Reset()
+	c.Assert(gsort.MergeSortedFiles([]string{plain, gz}, &got, key), IsNil)
+	c.Assert(got.String() == "#header 0\n"+strings.Join(lines, ""), Equals, true)
+}
+
+func (s *GSortTest) TestSortHeaderLine(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	key := func(line []byte) []int {
+		if bytes.HasPrefix(line, []byte("track")) || bytes.HasPrefix(line, []byte("browser")) {
+			return []int{gsort.HEADER_LINE}
+		}
+		return bed(line)
+	}
+	data := bedLines(40000)
+	var body bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader(data), &body, bed, 1, nil), IsNil)
+
+	// the marked lines are anywhere in unsorted and sorted input.
+	for _, d := range []string{data, body.String()} {
+		mid := strings.IndexByte(d[len(d)/2:], '\n') + len(d)/2 + 1
+		input := "#h\ntrack a\n" + d[:mid] + "browser b\n" + d[mid:] + "track c"
+		var got bytes.Buffer
+		c.Assert(gsort.Sort(strings.NewReader(input), &got, key, 1, nil), IsNil)
+		c.Assert(got.String() == "#h\ntrack a\nbrowser b\ntrack c\n"+body.String(), Equals, true)
+	}
+
+	var got bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader("track a\ntrack b\n"), &got, key, 1, nil), IsNil)
+	c.Assert(got.String(), Equals, "track a\ntrack b\n")
+}
+
+func (s *GSortTest) TestSortHeaderOrder(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	key := func(line []byte) []int {
+		if bytes.HasPrefix(line, []byte("track")) || bytes.HasPrefix(line, []byte("browser")) {
+			return []int{gsort.HEADER_LINE}
+		}
+		return bed(line)
+	}
+	data := wideBEDLines(5000)
+	var body bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader(data), &body, bed, 1, nil), IsNil)
+
+	// the marked and comment lines are in chunks that are spilled after the input is out of order,
+	// and keep their order.
+	lines := strings.SplitAfter(data, "\n")
+	input := "#h\ntrack a\n" + strings.Join(lines[:1000], "") + "//x\n" + strings.Join(lines[1000:2500], "") +
+		"browser b\n" + strings.Join(lines[2500:2510], "") + "//y\n" + strings.Join(lines[2510:], "") + "track c\n"
+	for _, workers := range []int{1, 3} {
+		var got bytes.Buffer
+		opts := &gsort.Options{MemMB: 1, Workers: workers, CommentPrefix: "//"}
+		c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, key, opts), IsNil)
+		c.Assert(got.String() == "#h\ntrack a\n//x\nbrowser b\n//y\ntrack c\n"+body.String(), Equals, true, Commentf("%d workers", workers))
+	}
+
+	// a chunk that is sorted in memory calls the processor once for each line.
+	calls := 0
+	counted := func(line []byte) []int {
+		calls++
+		return key(line)
+	}
+	small := "track a\n" + bedLines(1000) + "//x\nbrowser b\n"
+	var got bytes.Buffer
+	opts := &gsort.Options{CommentPrefix: "//"}
+	c.Assert(gsort.SortWithOptions(strings.NewReader(small), &got, counted, opts), IsNil)
+	c.Assert(calls, Equals, 1002)
+}
+
+func (s *GSortTest) TestSortSkipLines(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	opts := &gsort.Options{SkipLines: 2}
+	var got bytes.Buffer
+	input := "chrom\tstart\tend\nchr9\t5\t6\n#h\nchr2\t1\t2\nchr1\t1\t2\n"
+	c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, bed, opts), IsNil)
+	c.Assert(got.String(), Equals, "chrom\tstart\tend\nchr9\t5\t6\n#h\nchr1\t1\t2\nchr2\t1\t2\n")
+
+	// there may be fewer lines than are skipped.
+	got.Reset()
+	c.Assert(gsort.SortWithOptions(strings.NewReader("chrom\n"), &got, bed, opts), IsNil)
+	c.Assert(got.String(), Equals, "chrom\n")
+}
+
+func (s *GSortTest) TestSortComments(c *C) {
+	bed := gsort.Presets["bed"].Processor(nil)
+	data := bedLines(40000)
+	var body bytes.Buffer
+	c.Assert(gsort.Sort(strings.NewReader(data), &body, bed, 1, nil), IsNil)
+
+	third := strings.IndexByte(data[len(data)/3:], '\n') + len(data)/3 + 1
+	input := "#h\n" + data[:third] + "//a\n" + data[third:] + "//b\n"
+	for _, workers := range []int{1, 3} {
+		var got bytes.Buffer
+		opts := &gsort.Options{MemMB: 1, Workers: workers, CommentPrefix: "//"}
+		c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, bed, opts), IsNil)
+		c.Assert(got.String() == "#h\n//a\n//b\n"+body.String(), Equals, true, Commentf("%d workers", workers))
+	}
+}
+
+func (s *GSortTest) TestSortSpill(c *C) {
+	// 1MB is much less than the lines, so they are sorted in chunks that are merged.
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	data := wideBEDLines(5000)
+	lines := strings.SplitAfter(data, "\n")
+	lines = lines[:len(lines)-1]
+	bed := gsort.Presets["bed"].Processor(nil)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return keyLess(bed([]byte(lines[i])), bed([]byte(lines[j])))
+	})
+	for _, codec := range []gsort.Codec{gsort.CodecNone, gsort.CodecGzip} {
+		logged.Reset()
+		var got bytes.Buffer
+		opts := &gsort.Options{MemMB: 1, Codec: codec, TempDirs: []string{c.MkDir()}}
+		c.Assert(gsort.SortWithOptions(strings.NewReader(data), &got, bed, opts), IsNil)
+		c.Assert(got.String() == strings.Join(lines, ""), Equals, true, Commentf("codec %s", codec))
+		c.Assert(logged.String(), Matches, "(?s).*time to merge.*")
+		matches, err := filepath.Glob(filepath.Join(opts.TempDirs[0], "gsort.*"))
+		c.Assert(err, IsNil)
+		c.Assert(matches, HasLen, 0)
+	}
+}
+
+func (s *GSortTest) TestSortChromosomeMappings(c *C) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	order := gsort.NewContigOrder([]string{"chr1", "chr2", "chrX", "MT"})
+	bed := gsort.Presets["bed"].Processor(order)
+	mappings := map[string]string{"1": "chr1", "2": "chr2", "X": "chrX"}
+	var got bytes.Buffer
+	input := "X\t5\t6\nMT\t1\t2\n2\t1\t2\n1\t9\t10\n1\n"
+	c.Assert(gsort.Sort(strings.NewReader(input), &got, bed, 1, mappings), IsNil)
+	c.Assert(got.String(), Equals, "chr1\t9\t10\nchr1\nchr2\t1\t2\nchrX\t5\t6\nMT\t1\t2\n")
+	c.Assert(logged.String(), Matches, "(?s).*could not find mapping for chromosome: MT.*")
+
+	// the mappings are the same when the lines are spilled and merged.
+	data := bedLines(40000)
+	renamed := strings.NewReplacer("\nchr", "\n").Replace("\n" + data)[1:]
+	mappings = map[string]string{}
+	for i := 0; i < 23; i++ {
+		mappings[strconv.Itoa(i)] = fmt.Sprintf("chr%d", i)
+	}
+	var want bytes.Buffer
+	bed = gsort.Presets["bed"].Processor(nil)
+	c.Assert(gsort.Sort(strings.NewReader(data), &want, bed, 1, nil), IsNil)
+	got.Reset()
+	c.Assert(gsort.Sort(strings.NewReader(renamed), &got, bed, 1, mappings), IsNil)
+	c.Assert(got.String() == want.String(), Equals, true)
+}
+
+func (s *GSortTest) TestSortRename(c *C) {
+	// both contigs of a BEDPE are renamed, and the header has the new names.
+	m := rename.NewMap(map[string]string{"1": "chr1", "2": "chr2"}, rename.Strict)
+	bedpe := gsort.Presets["bedpe"].Processor(nil)
+	opts := &gsort.Options{Rename: rename.NewColumns(m, rename.Presets["bedpe"]...)}
+	var got bytes.Buffer
+	input := "#h\n2\t5\t6\t1\t1\t2\n1\t5\t6\t2\t1\t2\n"
+	c.Assert(gsort.SortWithOptions(strings.NewReader(input), &got, bedpe, opts), IsNil)
+	c.Assert(got.String(), Equals, "#h\nchr1\t5\t6\tchr2\t1\t2\nchr2\t5\t6\tchr1\t1\t2\n")
+
+	err := gsort.SortWithOptions(strings.NewReader(input+"1\t1\t2\tX\t1\t2\n"), ioutil.Discard, bedpe, opts)
+	c.Assert(err, ErrorMatches, ".*no mapping for contig X")
+}
+
+func (s *GSortTest) TestSortProperties(c *C) {
+	// a stable sort of random lines with many ties gives the same lines as sort.SliceStable in
+	// the same order, whether it is in one chunk or spilled, and an unstable sort gives them in
+	// an order with the same keys.
+	bed := gsort.Presets["bed"].Processor(nil)
+	prop := func(seed int64, big bool, workers uint8) bool {
+		rng := rand.New(rand.NewSource(seed))
+		n := rng.Intn(500)
+		if big {
+			n = 3000 + rng.Intn(3000)
+		}
+		// the padding makes a few thousand lines spill.
+		pad := strings.Repeat("p", 400)
+		lines := make([]string, n)
+		for i := range lines {
+			start := rng.Intn(100)
+			lines[i] = fmt.Sprintf("chr%d\t%d\t%d\t%d%s\n", rng.Intn(4), start, start+rng.Intn(3), i, pad)
+		}
+		input := strings.Join(lines, "")
+		sort.SliceStable(lines, func(i, j int) bool {
+			return keyLess(bed([]byte(lines[i])), bed([]byte(lines[j])))
+		})
+
+		for _, stable := range []bool{true, false} {
+			var got bytes.Buffer
+			opts := &gsort.Options{MemMB: 1, Workers: 1 + int(workers%3), Stable: stable}
+			if err := gsort.SortWithOptions(strings.NewReader(input), &got, bed, opts); err != nil {
+				c.Log(err)
+				return false
+			}
+			out := strings.SplitAfter(got.String(), "\n")
+			out = out[:len(out)-1]
+			if len(out) != len(lines) {
+				return false
+			}
+			for i := range out {
+				if stable && out[i] != lines[i] {
+					return false
+				}
+				if !stable && !reflect.DeepEqual(bed([]byte(out[i])), bed([]byte(lines[i]))) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	c.Assert(quick.Check(prop, &quick.Config{MaxCount: 8}), IsNil)
+}
+
+// bedReader generates BED lines of about size bytes without holding them in memory.
+type bedReader struct {
+	rng  *rand.Rand
+	left int
+	buf  []byte
+}
+
+func newBEDReader(size int) *bedReader {
+	return &bedReader{rng: rand.New(rand.NewSource(7)), left: size}
+}
+
+func (r *bedReader) Read(p []byte) (int, error) {
+	for len(r.buf) < len(p) && r.left > 0 {
+		s := r.rng.Intn(250000000)
+		n := len(r.buf)
+		r.buf = append(r.buf, fmt.Sprintf("chr%d\t%d\t%d\tname%d\n", 1+r.rng.Intn(22), s, s+r.rng.Intn(1000), r.rng.Intn(1000))...)
+		r.left -= len(r.buf) - n
+	}
+	if len(r.buf) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[:copy(r.buf, r.buf[n:])]
+	return n, nil
+}
+
+// peakHeap runs f and gives the largest heap that was sampled while it ran, over the heap before.
+func peakHeap(f func()) uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	base, peak := m.HeapAlloc, m.HeapAlloc
+	stop, stopped := make(chan bool), make(chan bool)
+	go func() {
+		var m runtime.MemStats
+		for {
+			select {
+			case <-stop:
+				close(stopped)
+				return
+			case <-time.After(time.Millisecond):
+				runtime.ReadMemStats(&m)
+				if m.HeapAlloc > peak {
+					peak = m.HeapAlloc
+				}
+			}
+		}
+	}()
+	f()
+	close(stop)
+	<-stopped
+	return peak - base
+}
+
+// BenchmarkSortPeakHeap sorts 64MB of BED in 48MB and reports the peak heap, which is an error if
+// it is over the budget. It is a benchmark as it takes seconds per configuration:
+//
+//	go test -run NONE -bench PeakHeap -benchtime 1x ./gsort
+func BenchmarkSortPeakHeap(b *testing.B) {
+	const memMB = 48
+	bed := gsort.Presets["bed"].Processor(nil)
+	for _, adaptive := range []bool{false, true} {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("workers=%d/adaptive=%v", workers, adaptive), func(b *testing.B) {
+				opts := &gsort.Options{MemMB: memMB, Workers: workers, AdaptiveMemory: adaptive}
+				var max uint64
+				for i := 0; i < b.N; i++ {
+					var err error
+					peak := peakHeap(func() {
+						err = gsort.SortWithOptions(newBEDReader(64<<20), ioutil.Discard, bed, opts)
+					})
+					if err != nil {
+						b.Fatal(err)
+					}
+					if peak > max {
+						max = peak
+					}
+				}
+				b.ReportMetric(float64(max)/(1<<20), "peak-MB")
+				if max > memMB<<20 {
+					b.Errorf("peak heap %.1fMB over %dMB", float64(max)/(1<<20), memMB)
+				}
+			})
+		}
+	}
+}
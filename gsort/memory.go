@@ -0,0 +1,126 @@
+package gsort
+
+import (
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// The memory of a chunk is estimated from what it allocates: each line, the slice header that
+// holds it, and the slice of ints that the Processor gives for it with its header. Allocations are
+// rounded up to a size class, which adds up to 1/8 on average.
+const (
+	sliceHeader = 24
+	// chunkBufSize is the buffer between the sorted lines and the codec of a temporary file.
+	chunkBufSize = 65536
+	// minChunkMemory keeps chunks from becoming tiny when the budget is mostly overhead.
+	minChunkMemory = 1 << 20
+	// adaptiveEvery is how many bytes of lines are read between heap measurements.
+	adaptiveEvery = 4 << 20
+	// gcSlack leaves room for the heap to overshoot its GC target and for the buffers of the
+	// reader and the merge.
+	gcSlack = 0.9
+)
+
+func allocSize(n int) int {
+	return n + n/8
+}
+
+// lineMemory estimates the memory of a line of n bytes with a key of k ints, not counting the
+// slice that holds the lines, which is counted by its capacity.
+func lineMemory(n, k int) int {
+	return allocSize(n) + allocSize(8*k) + sliceHeader
+}
+
+// gcHeadroom is how many times the live heap the Go heap may grow to before it is collected, as
+// set by GOGC.
+func gcHeadroom() float64 {
+	gogc := 100
+	if v, err := strconv.Atoi(os.Getenv("GOGC")); err == nil && v > 0 {
+		gogc = v
+	}
+	return 1 + float64(gogc)/100
+}
+
+// codecMemory estimates the memory of a writer for the codec of o.
+func (o *Options) codecMemory() int {
+	switch o.codec() {
+	case CodecNone:
+		return 0
+	case CodecGzip:
+		return 1 << 20
+	case CodecS2:
+		return 4 << 20
+	}
+	return 8 << 20
+}
+
+// chunkMemory gives the memory for the lines and keys of all chunks. The budget of MemMB is divided
+// by the GC headroom since the garbage of a written chunk is still on the heap while the next is
+// read, and the codecs of the workers are taken out.
+func (o *Options) chunkMemory() int {
+	w := o.workers()
+	live := int(gcSlack*float64(o.memMB()<<20)/gcHeadroom()) - w*(o.codecMemory()+chunkBufSize)
+	if live < minChunkMemory {
+		live = minChunkMemory
+	}
+	return live
+}
+
+// chunkBudget shares the memory from chunkMemory between the chunk being read and the chunks that
+// are waiting for or being written by the workers, from the estimated memory of their lines and
+// keys, so that each chunk is sized by what the others hold rather than by a fixed share.
+type chunkBudget struct {
+	live int64
+	// held is the memory of the chunks that have been sent and not yet written, and kept that of
+	// the header lines, which are kept until the output is written.
+	held, kept int64
+	released   chan struct{}
+}
+
+func newChunkBudget(live int) *chunkBudget {
+	return &chunkBudget{live: int64(live), released: make(chan struct{}, 1)}
+}
+
+// free is the memory that the chunk being read may use.
+func (b *chunkBudget) free() int {
+	return int(b.live - atomic.LoadInt64(&b.held) - atomic.LoadInt64(&b.kept))
+}
+
+// take accounts for a chunk that is sent with lines of memory in lines and header.
+func (b *chunkBudget) take(lines, header int) {
+	atomic.AddInt64(&b.held, int64(lines))
+	atomic.AddInt64(&b.kept, int64(header))
+}
+
+// release returns the memory of the lines of a chunk once they are written or dropped.
+func (b *chunkBudget) release(lines int) {
+	atomic.AddInt64(&b.held, -int64(lines))
+	select {
+	case b.released <- struct{}{}:
+	default:
+	}
+}
+
+// wait blocks until minChunkMemory is free or no chunk is held, so that a chunk is not started
+// with too little memory to be worth a temporary file. Only the reader waits.
+func (b *chunkBudget) wait() {
+	for b.free() < minChunkMemory && atomic.LoadInt64(&b.held) > 0 {
+		<-b.released
+	}
+}
+
+// overBudget measures the heap and reports whether the live data is over what is allowed for the
+// lines and keys of all chunks. The collector only runs when the heap is over MemMB.
+func (o *Options) overBudget() bool {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	budget := uint64(o.memMB()) << 20
+	if m.HeapAlloc <= budget {
+		return false
+	}
+	runtime.GC()
+	runtime.ReadMemStats(&m)
+	return float64(m.HeapAlloc) > gcSlack*float64(budget)/gcHeadroom()
+}
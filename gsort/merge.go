@@ -0,0 +1,62 @@
+package gsort
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+
+	gzip "github.com/klauspost/compress/gzip"
+	"github.com/pkg/errors"
+)
+
+// MergeSorted merges readers that are each already sorted by preprocess into wtr without
+// spilling to temporary files, for example to combine outputs that were sorted per chromosome.
+// The '#' header of the first reader is written and the headers of the others are skipped. Lines
+// that preprocess marks with HEADER_LINE are not moved to the header as Sort does, but written in
+// place, after the line before them in their input. Lines with equal keys are written in the order
+// of rdrs. An input that is not sorted is an error.
+func MergeSorted(rdrs []io.Reader, wtr io.Writer, preprocess Processor) error {
+	bwtr := bufio.NewWriter(wtr)
+	brdrs := make([]*bufio.Reader, len(rdrs))
+	for i, rdr := range rdrs {
+		brdrs[i] = bufio.NewReader(rdr)
+		// only the first header is written.
+		hwtr := bwtr
+		if i > 0 {
+			hwtr = bufio.NewWriter(ioutil.Discard)
+		}
+		if err := writeHeader(hwtr, brdrs[i]); err != nil && err != io.EOF {
+			return errors.Wrapf(err, "error reading/writing header of input %d", i)
+		}
+	}
+	if err := mergeReaders(brdrs, bwtr, preprocess, true, true, nil); err != nil {
+		return err
+	}
+	return errors.Wrap(bwtr.Flush(), "error writing output")
+}
+
+// MergeSortedFiles is MergeSorted for the files at paths, which may be gzipped.
+func MergeSortedFiles(paths []string, wtr io.Writer, preprocess Processor) error {
+	rdrs := make([]io.Reader, len(paths))
+	for i, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		brdr := bufio.NewReader(f)
+		if magic, _ := brdr.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			gz, err := gzip.NewReader(brdr)
+			if err != nil {
+				return errors.Wrapf(err, "error reading %s as gzip", path)
+			}
+			defer gz.Close()
+			rdrs[i] = gz
+		} else {
+			rdrs[i] = brdr
+		}
+	}
+	return MergeSorted(rdrs, wtr, preprocess)
+}
@@ -0,0 +1,217 @@
+package gsort
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+
+	gzip "github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Codec compresses the temporary files that sorted chunks are spilled to.
+type Codec string
+
+const (
+	// CodecNone writes the chunks uncompressed, which is fastest when the disk is.
+	CodecNone Codec = "none"
+	// CodecGzip is the default. Level is a gzip level and 0 means 1 (fastest).
+	CodecGzip Codec = "gzip"
+	// CodecZstd compresses better than gzip at about the same speed. Level is a zstd level and 0
+	// means 1 (fastest).
+	CodecZstd Codec = "zstd"
+	// CodecS2 is a very fast LZ4-like codec. Level 0 or 1 is the default, 2 is better and 3 is
+	// best compression.
+	CodecS2 Codec = "s2"
+)
+
+// Options configure SortWithOptions.
+type Options struct {
+	// MemMB is the memory to use in megabytes. The memory of the lines and their keys is
+	// estimated, and chunks are spilled to temporary files to keep it within MemMB.
+	MemMB int
+	// AdaptiveMemory also measures the heap as lines are read and spills a chunk early if the
+	// live heap is over budget, for when a Processor allocates more than its keys.
+	AdaptiveMemory bool
+	// ChromosomeMappings renames the first column of each line before it is sorted, with a
+	// warning for contigs that it doesn't have.
+	ChromosomeMappings map[string]string
+	// Rename renames the contigs of each line before it is sorted, as a rename.Columns does. It
+	// is used instead of ChromosomeMappings if both are set.
+	Rename Renamer
+	// TempDirs are the directories that chunks are spilled to, in turn. The default is
+	// os.TempDir().
+	TempDirs []string
+	// Codec compresses the temporary files. The default is CodecGzip.
+	Codec Codec
+	// Level is the compression level for Codec, with 0 the fastest level of the codec.
+	Level int
+	// Workers is the number of chunks that are sorted and written at once. The default is the
+	// number of CPUs, up to 4. Memory is shared between the chunks, so more workers means more,
+	// smaller temporary files.
+	Workers int
+	// Stable keeps lines with equal keys in the order of the input.
+	Stable bool
+	// SkipLines is the number of lines at the start of the input that are written first as they
+	// are, such as a line of column names.
+	SkipLines int
+	// CommentPrefix starts comment lines that may be anywhere in the input. They are not sorted
+	// and not kept in place, but hoisted to just after the header, in the order of the input. If
+	// it is empty, there are no comment lines.
+	CommentPrefix string
+}
+
+// DefaultMemMB is the memory used if Options.MemMB is 0.
+const DefaultMemMB = 2800
+
+// Renamer renames the contigs of a line. It returns an error for a line that can't be renamed.
+type Renamer interface {
+	Line(line []byte) ([]byte, error)
+}
+
+func (o *Options) renamer() Renamer {
+	if o.Rename != nil {
+		return o.Rename
+	}
+	if o.ChromosomeMappings != nil {
+		return &chromRenamer{names: o.ChromosomeMappings, warned: make(map[string]bool)}
+	}
+	return nil
+}
+
+// chromRenamer renames the first column of a line with names, and warns once for each contig
+// that names doesn't have.
+type chromRenamer struct {
+	names  map[string]string
+	warned map[string]bool
+}
+
+func (r *chromRenamer) Line(line []byte) ([]byte, error) {
+	i := bytes.IndexByte(line, '\t')
+	if i < 0 {
+		i = len(bytes.TrimRight(line, "\r\n"))
+	}
+	chrom := string(line[:i])
+	to, ok := r.names[chrom]
+	if !ok {
+		if !r.warned[chrom] {
+			r.warned[chrom] = true
+			log.Printf("[gsort] WARNING: could not find mapping for chromosome: %s", chrom)
+		}
+		return line, nil
+	}
+	return append([]byte(to), line[i:]...), nil
+}
+
+func (o *Options) memMB() int {
+	if o.MemMB <= 0 {
+		return DefaultMemMB
+	}
+	return o.MemMB
+}
+
+// maxDefaultWorkers limits the default number of workers: beyond it, the reader is the bottleneck.
+const maxDefaultWorkers = 4
+
+func (o *Options) workers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	if n := runtime.GOMAXPROCS(0); n < maxDefaultWorkers {
+		return n
+	}
+	return maxDefaultWorkers
+}
+
+func (o *Options) codec() Codec {
+	if o.Codec == "" {
+		return CodecGzip
+	}
+	return o.Codec
+}
+
+// ParseCodec checks the name of a codec.
+func ParseCodec(name string) (Codec, error) {
+	switch c := Codec(name); c {
+	case CodecNone, CodecGzip, CodecZstd, CodecS2:
+		return c, nil
+	case "":
+		return CodecGzip, nil
+	}
+	return "", fmt.Errorf("gsort: unknown codec %q: use none, gzip, zstd or s2", name)
+}
+
+// nopWriteCloser is an uncompressed chunk writer.
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// newChunkWriter compresses to w with the codec and level of o.
+func (o *Options) newChunkWriter(w io.Writer) (io.WriteCloser, error) {
+	switch o.codec() {
+	case CodecNone:
+		return nopWriteCloser{w}, nil
+	case CodecGzip:
+		level := o.Level
+		if level == 0 {
+			level = gzip.BestSpeed
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CodecZstd:
+		level := o.Level
+		if level == 0 {
+			level = 1
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)), zstd.WithEncoderConcurrency(1))
+	case CodecS2:
+		switch o.Level {
+		case 0, 1:
+			return s2.NewWriter(w, s2.WriterConcurrency(1)), nil
+		case 2:
+			return s2.NewWriter(w, s2.WriterConcurrency(1), s2.WriterBetterCompression()), nil
+		default:
+			return s2.NewWriter(w, s2.WriterConcurrency(1), s2.WriterBestCompression()), nil
+		}
+	}
+	return nil, fmt.Errorf("gsort: unknown codec %q", o.Codec)
+}
+
+// newChunkReader decompresses a chunk written by newChunkWriter.
+func (o *Options) newChunkReader(r io.Reader) (io.ReadCloser, error) {
+	switch o.codec() {
+	case CodecNone:
+		return ioutil.NopCloser(r), nil
+	case CodecGzip:
+		return gzip.NewReader(r)
+	case CodecZstd:
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	case CodecS2:
+		return ioutil.NopCloser(s2.NewReader(r)), nil
+	}
+	return nil, fmt.Errorf("gsort: unknown codec %q", o.Codec)
+}
+
+// usedTempDirs are the directories that may have temporary files, for the signal handler.
+var usedTempDirs sync.Map
+
+// tempFile creates the nth temporary file for a chunk, using the temporary directories in turn.
+func (o *Options) tempFile(n int) (*os.File, error) {
+	dir := os.TempDir()
+	if len(o.TempDirs) > 0 {
+		dir = o.TempDirs[n%len(o.TempDirs)]
+	}
+	usedTempDirs.Store(dir, true)
+	f, err := ioutil.TempFile(dir, fmt.Sprintf("gsort.%d.%d.", os.Getpid(), n))
+	return f, errors.Wrap(err, "error creating temporary file")
+}
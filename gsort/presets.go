@@ -0,0 +1,347 @@
+package gsort
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/biogo/hts/bam"
+	gzip "github.com/klauspost/compress/gzip"
+	"github.com/pkg/errors"
+)
+
+// naturalWidth is the number of ints in a natural-order key. Each int holds a run of digits or up
+// to naturalBytes bytes of other characters, so names are compared on about their first 30
+// characters. The keys fit in 32-bit ints.
+const (
+	naturalWidth = 10
+	naturalBytes = 3
+	// a run of digits sorts before text, as 1 < X. runs of digits are capped below it.
+	naturalText = 1 << 30
+)
+
+// ContigKeyWidth is the number of ints that ContigOrder.Key writes.
+const ContigKeyWidth = 1 + naturalWidth
+
+// ContigOrder ranks contigs in the order of a genome file, .fai or header. Contigs that it
+// doesn't know come after the known contigs in natural order (chr2 before chr10). A nil
+// *ContigOrder orders all contigs naturally.
+type ContigOrder struct {
+	ranks map[string]int
+}
+
+// NewContigOrder orders contigs as in names.
+func NewContigOrder(names []string) *ContigOrder {
+	o := &ContigOrder{ranks: make(map[string]int, len(names))}
+	for _, n := range names {
+		if _, ok := o.ranks[n]; !ok {
+			o.ranks[n] = len(o.ranks)
+		}
+	}
+	return o
+}
+
+// Len is the number of known contigs.
+func (o *ContigOrder) Len() int {
+	if o == nil {
+		return 0
+	}
+	return len(o.ranks)
+}
+
+// Key writes the ContigKeyWidth ints that order contig to dst.
+func (o *ContigOrder) Key(dst []int, contig []byte) {
+	if o != nil {
+		if r, ok := o.ranks[string(contig)]; ok {
+			dst[0] = r
+			for i := 1; i < ContigKeyWidth; i++ {
+				dst[i] = 0
+			}
+			return
+		}
+	}
+	dst[0] = o.Len()
+	NaturalKey(dst[1:ContigKeyWidth], contig)
+}
+
+// NaturalKey writes a key to dst that orders strings with runs of digits compared as numbers,
+// so that 2 comes before 10. Strings that only differ after len(dst) runs (or after a long run of
+// text fills dst) get the same key.
+func NaturalKey(dst []int, s []byte) {
+	k := 0
+	for i := 0; i < len(s) && k < len(dst); k++ {
+		if isDigit(s[i]) {
+			n := 0
+			for ; i < len(s) && isDigit(s[i]); i++ {
+				if n < naturalText/10 {
+					n = n*10 + int(s[i]-'0')
+				}
+			}
+			dst[k] = n
+			continue
+		}
+		v, j := 0, 0
+		for ; j < naturalBytes && i < len(s) && !isDigit(s[i]); i, j = i+1, j+1 {
+			v = v<<8 | int(s[i])
+		}
+		// left-align short runs so that "a" < "ab".
+		dst[k] = naturalText | v<<(8*uint(naturalBytes-j))
+	}
+	for ; k < len(dst); k++ {
+		dst[k] = -1
+	}
+}
+
+func isDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// ReadContigOrder reads the order of contigs from a BAM header (.bam), the ##contig lines of a
+// VCF header (.vcf or .vcf.gz), the @SQ lines of a SAM header or sequence dictionary (.sam or
+// .dict) or the first column of a genome file or .fai.
+func ReadContigOrder(path string) (*ContigOrder, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if strings.HasSuffix(path, ".bam") {
+		br, err := bam.NewReader(f, 1)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading bam header from %s", path)
+		}
+		defer br.Close()
+		var names []string
+		for _, r := range br.Header().Refs() {
+			names = append(names, r.Name())
+		}
+		return NewContigOrder(names), nil
+	}
+	var rdr io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s as gzip", path)
+		}
+		defer gz.Close()
+		rdr = gz
+	}
+	brdr := bufio.NewReader(rdr)
+	switch ext := filepath.Ext(strings.TrimSuffix(path, ".gz")); ext {
+	case ".vcf", ".sam", ".dict":
+		prefix := byte('#')
+		if ext != ".vcf" {
+			prefix = '@'
+		}
+		header, err := ReadHeader(brdr, prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading header from %s", path)
+		}
+		return ContigOrderFromHeader(header), nil
+	}
+	var names []string
+	for {
+		line, err := brdr.ReadBytes('\n')
+		if len(line) > 0 && line[0] != '#' {
+			if f := bytes.Fields(line); len(f) > 0 {
+				names = append(names, string(f[0]))
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "error reading %s", path)
+		}
+	}
+	return NewContigOrder(names), nil
+}
+
+// ContigOrderFromHeader gives the order of the ##contig lines of a VCF header or the @SQ lines
+// of a SAM header. It returns nil if there are none.
+func ContigOrderFromHeader(header [][]byte) *ContigOrder {
+	var names []string
+	for _, line := range header {
+		line = bytes.TrimRight(line, "\r\n")
+		switch {
+		case bytes.HasPrefix(line, []byte("##contig=<")):
+			for _, kv := range bytes.Split(bytes.TrimSuffix(line[len("##contig=<"):], []byte{'>'}), []byte{','}) {
+				if bytes.HasPrefix(kv, []byte("ID=")) {
+					names = append(names, string(kv[3:]))
+				}
+			}
+		case bytes.HasPrefix(line, []byte("@SQ\t")):
+			for _, kv := range bytes.Split(line, []byte{'\t'}) {
+				if bytes.HasPrefix(kv, []byte("SN:")) {
+					names = append(names, string(kv[3:]))
+				}
+			}
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return NewContigOrder(names)
+}
+
+// ReadHeader reads the lines at the start of rdr that begin with prefix.
+func ReadHeader(rdr *bufio.Reader, prefix byte) ([][]byte, error) {
+	var header [][]byte
+	for {
+		b, err := rdr.Peek(1)
+		if err == io.EOF {
+			return header, nil
+		}
+		if err != nil {
+			return header, err
+		}
+		if b[0] != prefix {
+			return header, nil
+		}
+		line, err := rdr.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return header, err
+		}
+		header = append(header, line)
+	}
+}
+
+// Preset is a Processor for a common format.
+type Preset struct {
+	Name string
+	// HeaderPrefix starts the header lines of the format.
+	HeaderPrefix byte
+	// Processor gives the Processor that orders lines by contig in the given order.
+	Processor func(order *ContigOrder) Processor
+}
+
+// Presets has a Preset for BED, BEDPE, VCF, GFF, GTF and SAM, keyed by name.
+var Presets = map[string]Preset{
+	"bed":   {"bed", '#', BEDProcessor},
+	"bedpe": {"bedpe", '#', BEDPEProcessor},
+	"vcf":   {"vcf", '#', VCFProcessor},
+	"gff":   {"gff", '#', GFFProcessor},
+	"gtf":   {"gtf", '#', GFFProcessor},
+	"sam":   {"sam", '@', SAMProcessor},
+}
+
+// PresetFromPath gives the Preset for the extension of path, ignoring a .gz or .bgz suffix.
+func PresetFromPath(path string) (Preset, bool) {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".bgz")
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext == "gff3" {
+		ext = "gff"
+	}
+	p, ok := Presets[ext]
+	return p, ok
+}
+
+// fields splits the first n tab-delimited fields from line. There are fewer if line has fewer.
+func fields(line []byte, n int) [][]byte {
+	line = bytes.TrimRight(line, "\r\n")
+	out := make([][]byte, 0, n)
+	for len(out) < n-1 {
+		i := bytes.IndexByte(line, '\t')
+		if i < 0 {
+			break
+		}
+		out = append(out, line[:i])
+		line = line[i+1:]
+	}
+	if i := bytes.IndexByte(line, '\t'); i >= 0 {
+		line = line[:i]
+	}
+	return append(out, line)
+}
+
+// atoi parses a position. Missing or bad values sort last.
+func atoi(b []byte) int {
+	v, err := strconv.Atoi(string(b))
+	if err != nil {
+		return math.MaxInt32
+	}
+	return v
+}
+
+// field gives the ith of toks or nil.
+func field(toks [][]byte, i int) []byte {
+	if i < len(toks) {
+		return toks[i]
+	}
+	return nil
+}
+
+// BEDProcessor orders BED lines by contig, start and end.
+func BEDProcessor(order *ContigOrder) Processor {
+	return func(line []byte) []int {
+		toks := fields(line, 3)
+		key := make([]int, ContigKeyWidth+2)
+		order.Key(key, toks[0])
+		key[ContigKeyWidth] = atoi(field(toks, 1))
+		key[ContigKeyWidth+1] = atoi(field(toks, 2))
+		return key
+	}
+}
+
+// BEDPEProcessor orders BEDPE lines by the contig, start and end of the first and then the
+// second end.
+func BEDPEProcessor(order *ContigOrder) Processor {
+	const w = ContigKeyWidth + 2
+	return func(line []byte) []int {
+		toks := fields(line, 6)
+		key := make([]int, 2*w)
+		for e := 0; e < 2; e++ {
+			order.Key(key[e*w:], field(toks, 3*e))
+			key[e*w+ContigKeyWidth] = atoi(field(toks, 3*e+1))
+			key[e*w+ContigKeyWidth+1] = atoi(field(toks, 3*e+2))
+		}
+		return key
+	}
+}
+
+// VCFProcessor orders VCF lines by contig, position and the end of the reference allele.
+func VCFProcessor(order *ContigOrder) Processor {
+	return func(line []byte) []int {
+		toks := fields(line, 4)
+		key := make([]int, ContigKeyWidth+2)
+		order.Key(key, toks[0])
+		pos := atoi(field(toks, 1))
+		key[ContigKeyWidth] = pos
+		key[ContigKeyWidth+1] = pos + len(field(toks, 3))
+		return key
+	}
+}
+
+// GFFProcessor orders GFF and GTF lines by contig and start, with longer features first so that
+// a gene comes before its transcripts.
+func GFFProcessor(order *ContigOrder) Processor {
+	return func(line []byte) []int {
+		toks := fields(line, 5)
+		key := make([]int, ContigKeyWidth+2)
+		order.Key(key, toks[0])
+		key[ContigKeyWidth] = atoi(field(toks, 3))
+		key[ContigKeyWidth+1] = -atoi(field(toks, 4))
+		return key
+	}
+}
+
+// SAMProcessor orders SAM lines by reference and position, with unmapped reads ("*") last.
+func SAMProcessor(order *ContigOrder) Processor {
+	return func(line []byte) []int {
+		toks := fields(line, 4)
+		key := make([]int, ContigKeyWidth+1)
+		ref := field(toks, 2)
+		if len(ref) == 0 || ref[0] == '*' {
+			key[0] = math.MaxInt32
+			return key
+		}
+		order.Key(key, ref)
+		key[ContigKeyWidth] = atoi(field(toks, 3))
+		return key
+	}
+}
@@ -0,0 +1,165 @@
+// Package sortcmd is the sort sub-command, which sorts files with gsort.
+package sortcmd
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/gsort"
+	"github.com/Schaudge/ngsutils/rename"
+	"github.com/Schaudge/ngsutils/utils"
+	arg "github.com/alexflint/go-arg"
+)
+
+type cliarg struct {
+	Preset         string   `arg:"-p,help:format of the input: bed or bedpe or vcf or gff or gtf or sam. guessed from the file name if not given"`
+	Genome         string   `arg:"-g,help:genome file or .fai or bam or vcf or sequence dictionary giving the contig order. contigs not in it are sorted naturally after those that are"`
+	Memory         int      `arg:"-m,help:megabytes of memory to use before writing sorted chunks to temporary files"`
+	Adaptive       bool     `arg:"--adaptive-memory,help:also measure the heap and write chunks early if it is over --memory"`
+	ChromMappings  string   `arg:"-c,help:file of two columns mapping contig names in the input to names in the output. the columns of --preset with contigs are renamed"`
+	StrictMappings bool     `arg:"--strict-mappings,help:fail on contigs that are not in --chrommappings instead of warning"`
+	TempDirs       []string `arg:"-T,--temp-dir,separate,help:directory for temporary files. repeat to use several in turn"`
+	Compress       string   `arg:"help:codec for temporary files: none or gzip or zstd or s2"`
+	Level          int      `arg:"help:compression level of the codec. 0 is its fastest level"`
+	Threads        int      `arg:"-j,help:chunks to sort and write at once. 0 uses the number of CPUs up to 4"`
+	Stable         bool     `arg:"-s,help:keep lines with equal keys in the order of the input"`
+	Skip           int      `arg:"help:number of lines at the start to write first as they are"`
+	Comment        string   `arg:"help:prefix of comment lines anywhere in the input to write after the header instead of sorting. with --merge they are kept in place"`
+	Merge          bool     `arg:"help:merge inputs that are each already sorted without temporary files"`
+	Inputs         []string `arg:"positional,help:file to sort or files to --merge. reads stdin if not given"`
+}
+
+// openInput opens path or stdin for "-".
+func openInput(path string) (*bufio.Reader, io.Closer, error) {
+	if path == "-" {
+		return bufio.NewReader(os.Stdin), ioutil.NopCloser(nil), nil
+	}
+	f, err := utils.Ropen(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f.Reader, f, nil
+}
+
+// Main is the entry-point for the sort sub-command. It sorts a BED, BEDPE, VCF, GFF, GTF or SAM
+// by contig and position using a limited amount of memory, or merges files that are each sorted.
+func Main() {
+	cli := &cliarg{Memory: gsort.DefaultMemMB, Compress: string(gsort.CodecGzip)}
+	p := arg.MustParse(cli)
+	if len(cli.Inputs) == 0 {
+		cli.Inputs = []string{"-"}
+	}
+	if len(cli.Inputs) > 1 && !cli.Merge {
+		p.Fail("only one file can be sorted. use --merge to merge sorted files")
+	}
+	if cli.Merge && cli.ChromMappings != "" {
+		p.Fail("--chrommappings can't be used with --merge")
+	}
+	preset, ok := gsort.Presets[strings.ToLower(cli.Preset)]
+	if cli.Preset == "" {
+		preset, ok = gsort.PresetFromPath(cli.Inputs[0])
+	}
+	if !ok {
+		p.Fail("the format must be given with --preset when it can't be guessed from the file name")
+	}
+	codec, err := gsort.ParseCodec(cli.Compress)
+	if err != nil {
+		p.Fail(err.Error())
+	}
+
+	// the skipped lines and header of the first input are written and those of the others are
+	// dropped. The skipped lines are written as they are.
+	rdrs := make([]io.Reader, len(cli.Inputs))
+	var skipped, header [][]byte
+	for i, path := range cli.Inputs {
+		rdr, c, err := openInput(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer c.Close()
+		var s [][]byte
+		for k := 0; k < cli.Skip; k++ {
+			line, err := rdr.ReadBytes('\n')
+			if err != nil && err != io.EOF {
+				log.Fatal(err)
+			}
+			s = append(s, line)
+		}
+		h, err := gsort.ReadHeader(rdr, preset.HeaderPrefix)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if i == 0 {
+			skipped, header = s, h
+		}
+		rdrs[i] = rdr
+	}
+
+	var renamer *rename.Columns
+	if cli.ChromMappings != "" {
+		mode := rename.Warn
+		if cli.StrictMappings {
+			mode = rename.Strict
+		}
+		m, err := rename.ReadMap(cli.ChromMappings, "", "", mode)
+		if err != nil {
+			log.Fatal(err)
+		}
+		renamer = rename.NewColumns(m, rename.Presets[preset.Name]...)
+		// the contigs of the header are renamed too, so that its order has the new names.
+		for i, line := range header {
+			if header[i], err = renamer.HeaderLine(line); err != nil {
+				log.Fatal(err)
+			}
+		}
+	}
+
+	var order *gsort.ContigOrder
+	if cli.Genome != "" {
+		if order, err = gsort.ReadContigOrder(cli.Genome); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		order = gsort.ContigOrderFromHeader(header)
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	for _, line := range skipped {
+		w.Write(line)
+	}
+	for _, line := range header {
+		w.Write(line)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if cli.Merge {
+		process := preset.Processor(order)
+		if cli.Comment != "" {
+			// MergeSorted writes marked lines in place.
+			key, comment := process, []byte(cli.Comment)
+			process = func(line []byte) []int {
+				if bytes.HasPrefix(line, comment) {
+					return []int{gsort.HEADER_LINE}
+				}
+				return key(line)
+			}
+		}
+		if err := gsort.MergeSorted(rdrs, os.Stdout, process); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	opts := &gsort.Options{MemMB: cli.Memory, TempDirs: cli.TempDirs, Codec: codec, Level: cli.Level, Workers: cli.Threads, Stable: cli.Stable, AdaptiveMemory: cli.Adaptive, CommentPrefix: cli.Comment}
+	if renamer != nil {
+		opts.Rename = renamer
+	}
+	if err := gsort.SortWithOptions(rdrs[0], os.Stdout, preset.Processor(order), opts); err != nil {
+		log.Fatal(err)
+	}
+}
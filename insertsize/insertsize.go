@@ -0,0 +1,315 @@
+// Package insertsize estimates the insert-size (template length) distribution of a BAM, overall
+// and per read group, and derives the distance at which mates are considered discordant.
+//
+// Estimates can be saved as JSON and loaded again so that the BAM only has to be sampled once.
+package insertsize
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+)
+
+// MinReadGroupPairs is the number of pairs a read group needs to get its own estimate. Reads from
+// read groups with fewer pairs use the overall estimate.
+const MinReadGroupPairs = 1000
+
+// DefaultMultiplier gives a discordant distance of mean + 5 * SD, as excord has always used.
+const DefaultMultiplier = 5
+
+// Cutoff determines the discordant distance from a distribution. If Percentile is greater than 0,
+// the distance is that percentile of the insert sizes, otherwise it is Mean + Multiplier * SD.
+type Cutoff struct {
+	Multiplier float64 `json:"multiplier,omitempty"`
+	Percentile float64 `json:"percentile,omitempty"`
+}
+
+// Estimate summarizes the insert sizes of one read group (or of all reads).
+type Estimate struct {
+	ReadGroup string  `json:"read_group,omitempty"`
+	Pairs     int     `json:"pairs"`
+	Mean      float64 `json:"mean"`
+	SD        float64 `json:"sd"`
+	Median    float64 `json:"median"`
+	MAD       float64 `json:"mad"`
+	Distance  int     `json:"discordant_distance"`
+}
+
+// Model holds the estimates for a BAM.
+type Model struct {
+	Cutoff     Cutoff               `json:"cutoff"`
+	All        *Estimate            `json:"all"`
+	ReadGroups map[string]*Estimate `json:"read_groups,omitempty"`
+}
+
+// Distance returns the discordant distance for the read group, or the overall distance if the
+// read group is unknown.
+func (m *Model) Distance(readGroup string) int {
+	if e, ok := m.ReadGroups[readGroup]; ok && readGroup != "" {
+		return e.Distance
+	}
+	return m.All.Distance
+}
+
+// Save writes the model as JSON.
+func (m *Model) Save(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Load reads a model written by Save.
+func Load(path string) (*Model, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	m := &Model{}
+	if err := json.NewDecoder(f).Decode(m); err != nil {
+		return nil, fmt.Errorf("insertsize: error reading %s: %w", path, err)
+	}
+	if m.All == nil || m.All.Distance <= 0 {
+		return nil, fmt.Errorf("insertsize: %s does not contain an overall estimate", path)
+	}
+	for rg, e := range m.ReadGroups {
+		if e == nil || e.ReadGroup != rg || e.Distance <= 0 {
+			return nil, fmt.Errorf("insertsize: %s has an invalid estimate for read group %s", path, rg)
+		}
+	}
+	return m, nil
+}
+
+// ReadGroup returns the value of the RG tag of r or "" if it has none.
+func ReadGroup(r *sam.Record) string {
+	if v, ok := r.Tag([]byte{'R', 'G'}); ok {
+		if s, ok := v.Value().(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+const bad = sam.Unmapped | sam.MateUnmapped | sam.Duplicate | sam.Secondary | sam.Supplementary | sam.QCFail
+
+// Sampler collects insert sizes from a stream of records.
+type Sampler struct {
+	max   int
+	n     int
+	all   []int
+	byRG  map[string][]int
+	order []string
+}
+
+// NewSampler returns a Sampler that takes up to maxPairs pairs.
+func NewSampler(maxPairs int) *Sampler {
+	return &Sampler{max: maxPairs, byRG: make(map[string][]int)}
+}
+
+// Add uses r if it is the left read of a properly-paired, primary pair. It returns false
+// once the sampler has enough pairs.
+func (s *Sampler) Add(r *sam.Record) bool {
+	if s.n >= s.max {
+		return false
+	}
+	if r.Flags&bad != 0 || r.Flags&sam.ProperPair == 0 || r.TempLen <= 0 || r.Ref.ID() != r.MateRef.ID() {
+		return true
+	}
+	rg := ReadGroup(r)
+	if _, ok := s.byRG[rg]; !ok {
+		s.order = append(s.order, rg)
+	}
+	s.byRG[rg] = append(s.byRG[rg], r.TempLen)
+	s.all = append(s.all, r.TempLen)
+	s.n++
+	return s.n < s.max
+}
+
+// Pairs is the number of pairs sampled so far.
+func (s *Sampler) Pairs() int {
+	return s.n
+}
+
+// Model summarizes the sampled pairs.
+func (s *Sampler) Model(c Cutoff) (*Model, error) {
+	if s.n == 0 {
+		return nil, fmt.Errorf("insertsize: no properly paired reads found")
+	}
+	m := &Model{Cutoff: c, All: estimate(s.all, c), ReadGroups: make(map[string]*Estimate)}
+	for _, rg := range s.order {
+		if rg == "" || len(s.byRG[rg]) < MinReadGroupPairs {
+			continue
+		}
+		e := estimate(s.byRG[rg], c)
+		e.ReadGroup = rg
+		m.ReadGroups[rg] = e
+	}
+	return m, nil
+}
+
+// FromReader samples up to maxPairs pairs from br.
+func FromReader(br *bam.Reader, maxPairs int, c Cutoff) (*Model, error) {
+	s := NewSampler(maxPairs)
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !s.Add(r) {
+			break
+		}
+	}
+	return s.Model(c)
+}
+
+// FromPath samples up to maxPairs pairs from the BAM at path. A path of "-" reads from stdin.
+func FromPath(path string, maxPairs int, c Cutoff) (*Model, error) {
+	var rdr io.Reader = os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		rdr = f
+	}
+	br, err := bam.NewReader(bufio.NewReader(rdr), 1)
+	if err != nil {
+		return nil, err
+	}
+	defer br.Close()
+	return FromReader(br, maxPairs, c)
+}
+
+func median(sorted []int) float64 {
+	n := len(sorted)
+	if n%2 == 1 {
+		return float64(sorted[n/2])
+	}
+	return float64(sorted[n/2-1]+sorted[n/2]) / 2
+}
+
+// estimate summarizes sizes (which it sorts). Sizes more than 10 MADs above the median are
+// ignored for the mean and SD so that a few chimeric pairs don't inflate the cutoff.
+func estimate(sizes []int, c Cutoff) *Estimate {
+	sort.Ints(sizes)
+	e := &Estimate{Pairs: len(sizes), Median: median(sizes)}
+	devs := make([]int, len(sizes))
+	for i, v := range sizes {
+		devs[i] = int(math.Abs(float64(v) - e.Median))
+	}
+	sort.Ints(devs)
+	e.MAD = median(devs)
+
+	limit := e.Median + 10*e.MAD
+	var sum, sum2 float64
+	n := 0
+	for _, v := range sizes {
+		if float64(v) > limit && e.MAD > 0 {
+			break
+		}
+		sum += float64(v)
+		sum2 += float64(v) * float64(v)
+		n++
+	}
+	e.Mean = sum / float64(n)
+	e.SD = math.Sqrt(math.Max(0, sum2/float64(n)-e.Mean*e.Mean))
+
+	if c.Percentile > 0 {
+		i := int(math.Ceil(c.Percentile/100*float64(len(sizes)))) - 1
+		if i < 0 {
+			i = 0
+		} else if i >= len(sizes) {
+			i = len(sizes) - 1
+		}
+		e.Distance = sizes[i]
+	} else {
+		mult := c.Multiplier
+		if mult == 0 {
+			mult = DefaultMultiplier
+		}
+		e.Distance = int(e.Mean + mult*e.SD)
+	}
+	return e
+}
+
+// FindModel returns the path of a saved model next to a BAM (foo.bam.insert.json or
+// foo.insert.json) or "" if there is none.
+func FindModel(bamPath string) string {
+	for _, p := range []string{bamPath + ".insert.json", strings.TrimSuffix(bamPath, ".bam") + ".insert.json"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+type cliarg struct {
+	Pairs      int     `arg:"-n,help:number of pairs to sample"`
+	Multiplier float64 `arg:"-m,help:discordant distance is mean + multiplier * SD"`
+	Percentile float64 `arg:"-p,help:use this percentile of insert sizes as the discordant distance instead of the multiplier"`
+	Output     string  `arg:"-o,help:write the estimates as JSON to this path for use by excord"`
+	BamPath    string  `arg:"positional,help:BAM to sample. reads from stdin if not given or -"`
+}
+
+// Main is the entry-point for the insert-size sub-command. It reports the estimates per read
+// group and optionally saves them.
+func Main() {
+	cli := &cliarg{Pairs: 1e6, Multiplier: DefaultMultiplier, BamPath: "-"}
+	arg.MustParse(cli)
+	m, err := FromPath(cli.BamPath, cli.Pairs, Cutoff{Multiplier: cli.Multiplier, Percentile: cli.Percentile})
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	fmt.Fprintln(w, "#read_group\tpairs\tmean\tsd\tmedian\tmad\tdiscordant_distance")
+	rgs := make([]string, 0, len(m.ReadGroups))
+	for rg := range m.ReadGroups {
+		rgs = append(rgs, rg)
+	}
+	sort.Strings(rgs)
+	for _, e := range append([]*Estimate{m.All}, m.estimates(rgs)...) {
+		rg := e.ReadGroup
+		if rg == "" {
+			rg = "all"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%d\n", rg, e.Pairs, e.Mean, e.SD, e.Median, e.MAD, e.Distance)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+	if cli.Output != "" {
+		if err := m.Save(cli.Output); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (m *Model) estimates(rgs []string) []*Estimate {
+	es := make([]*Estimate, len(rgs))
+	for i, rg := range rgs {
+		es[i] = m.ReadGroups[rg]
+	}
+	return es
+}
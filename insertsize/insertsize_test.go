@@ -0,0 +1,189 @@
+package insertsize
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestMedian(t *testing.T) {
+	for _, tc := range []struct {
+		sorted []int
+		want   float64
+	}{
+		{[]int{7}, 7},
+		{[]int{1, 2, 10}, 2},
+		{[]int{1, 2, 10, 11}, 6},
+		{[]int{300, 301}, 300.5},
+	} {
+		if got := median(tc.sorted); got != tc.want {
+			t.Errorf("median(%v) = %v, want %v", tc.sorted, got, tc.want)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	// the chimeric 10000 is more than 10 MADs above the median so it is not in the mean or SD.
+	sizes := func() []int { return []int{340, 10000, 300, 330, 310, 320} }
+	e := estimate(sizes(), Cutoff{})
+	if e.Pairs != 6 || e.Median != 325 || e.MAD != 15 || e.Mean != 320 || math.Abs(e.SD-math.Sqrt(200)) > 1e-9 {
+		t.Fatalf("estimate() = %+v", e)
+	}
+	for _, tc := range []struct {
+		c    Cutoff
+		want int
+	}{
+		{Cutoff{}, 390},
+		{Cutoff{Multiplier: 3}, 362},
+		// a percentile takes precedence over the multiplier and does not ignore outliers.
+		{Cutoff{Multiplier: 3, Percentile: 50}, 320},
+		{Cutoff{Percentile: 80}, 340},
+		{Cutoff{Percentile: 90}, 10000},
+		{Cutoff{Percentile: 100}, 10000},
+		{Cutoff{Percentile: 0.1}, 300},
+	} {
+		if got := estimate(sizes(), tc.c).Distance; got != tc.want {
+			t.Errorf("estimate() with %+v has distance %d, want %d", tc.c, got, tc.want)
+		}
+	}
+
+	// with a MAD of 0 nothing is trimmed.
+	e = estimate([]int{400, 400, 400, 900}, Cutoff{})
+	if e.MAD != 0 || e.Mean != 525 {
+		t.Fatalf("estimate() = %+v", e)
+	}
+}
+
+func newPair(t *testing.T, ref *sam.Reference, tlen int, flags sam.Flags, rg string) *sam.Record {
+	t.Helper()
+	r := &sam.Record{Name: "r", Ref: ref, MateRef: ref, Pos: 100, MatePos: 100 + tlen/2, TempLen: tlen, Flags: flags}
+	if rg != "" {
+		aux, err := sam.NewAux(sam.NewTag("RG"), rg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.AuxFields = append(r.AuxFields, aux)
+	}
+	return r
+}
+
+func TestSamplerModel(t *testing.T) {
+	ref, err := sam.NewReference("1", "", "", 1e6, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref}); err != nil {
+		t.Fatal(err)
+	}
+	const proper = sam.Paired | sam.ProperPair
+	s := NewSampler(2 * MinReadGroupPairs)
+	if _, err := s.Model(Cutoff{}); err == nil {
+		t.Fatal("expected an error without pairs")
+	}
+	for _, r := range []*sam.Record{
+		newPair(t, ref, -300, proper, "a"),
+		newPair(t, ref, 300, sam.Paired, "a"),
+		newPair(t, ref, 300, proper|sam.Duplicate, "a"),
+		newPair(t, ref, 300, proper|sam.Secondary, "a"),
+	} {
+		s.Add(r)
+	}
+	if s.Pairs() != 0 {
+		t.Fatalf("sampled %d pairs from reads that are not the left read of a proper pair", s.Pairs())
+	}
+	// a has enough pairs for its own estimate, b and reads without a read group use the overall one.
+	for i := 0; i < MinReadGroupPairs; i++ {
+		s.Add(newPair(t, ref, 500+i%2*10, proper, "a"))
+	}
+	for i := 0; i < MinReadGroupPairs/2; i++ {
+		s.Add(newPair(t, ref, 200, proper, "b"))
+		s.Add(newPair(t, ref, 200, proper, ""))
+	}
+	if s.Pairs() != 2*MinReadGroupPairs {
+		t.Fatalf("sampled %d pairs", s.Pairs())
+	}
+	if s.Add(newPair(t, ref, 200, proper, "b")) {
+		t.Fatal("the sampler took more pairs than its maximum")
+	}
+	m, err := s.Model(Cutoff{Percentile: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.ReadGroups) != 1 || m.ReadGroups["a"] == nil || m.ReadGroups["a"].ReadGroup != "a" {
+		t.Fatalf("read groups are %v", m.ReadGroups)
+	}
+	for rg, want := range map[string]int{"a": 500, "b": 200, "": 200, "c": 200} {
+		if got := m.Distance(rg); got != want {
+			t.Errorf("Distance(%q) = %d, want %d", rg, got, want)
+		}
+	}
+	if m.All.Pairs != 2*MinReadGroupPairs || m.All.Median != 350 {
+		t.Fatalf("overall estimate is %+v", m.All)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	m := &Model{Cutoff: Cutoff{Multiplier: 4}, All: &Estimate{Pairs: 10, Mean: 300, Distance: 420},
+		ReadGroups: map[string]*Estimate{"a": {ReadGroup: "a", Pairs: 10, Mean: 250, Distance: 330}}}
+	path := filepath.Join(dir, "s.bam.insert.json")
+	if err := m.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Distance("a") != 330 || got.Distance("b") != 420 || got.Cutoff.Multiplier != 4 {
+		t.Fatalf("Load() = %+v", got)
+	}
+
+	for name, s := range map[string]string{
+		"truncated":  `{"all": {"pairs": 10, "discordant_distance": 4`,
+		"array":      `[{"pairs": 10}]`,
+		"no overall": `{"cutoff": {}, "read_groups": {"a": {"read_group": "a", "discordant_distance": 330}}}`,
+		"coverage":   `{"magic": "excord-coverage", "version": 2, "chrom": "1", "length": 100}`,
+		"distance":   `{"all": {"pairs": 10, "discordant_distance": 0}}`,
+		"bad type":   `{"all": {"pairs": "ten", "discordant_distance": 400}}`,
+		"rg missing": `{"all": {"discordant_distance": 400}, "read_groups": {"a": null}}`,
+		"rg name":    `{"all": {"discordant_distance": 400}, "read_groups": {"a": {"read_group": "b", "discordant_distance": 330}}}`,
+		"rg dist":    `{"all": {"discordant_distance": 400}, "read_groups": {"a": {"read_group": "a", "discordant_distance": -1}}}`,
+	} {
+		p := filepath.Join(dir, strings.ReplaceAll(name, " ", "-")+".json")
+		if err := os.WriteFile(p, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Load(p); err == nil {
+			t.Errorf("Load() of %s JSON did not give an error", name)
+		}
+	}
+	if _, err := Load(filepath.Join(dir, "missing.json")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
+
+func TestFindModel(t *testing.T) {
+	dir := t.TempDir()
+	bam := filepath.Join(dir, "s.bam")
+	if p := FindModel(bam); p != "" {
+		t.Fatalf("FindModel() = %q without a model", p)
+	}
+	short := filepath.Join(dir, "s.insert.json")
+	if err := os.WriteFile(short, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := FindModel(bam); p != short {
+		t.Fatalf("FindModel() = %q, want %q", p, short)
+	}
+	long := bam + ".insert.json"
+	if err := os.WriteFile(long, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if p := FindModel(bam); p != long {
+		t.Fatalf("FindModel() = %q, want %q", p, long)
+	}
+}
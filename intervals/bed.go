@@ -0,0 +1,216 @@
+package intervals
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/utils"
+)
+
+// BED is a record from a BED file. Fields has the columns after the third.
+type BED struct {
+	Chrom      string
+	Start, End int
+	Fields     []string
+}
+
+// Name is the fourth column or "".
+func (b *BED) Name() string {
+	if len(b.Fields) > 0 {
+		return b.Fields[0]
+	}
+	return ""
+}
+
+// BEDPE is a record from a BEDPE file. Strands are "+", "-" or "." ("" if the columns are
+// missing) and Fields has the columns after the tenth.
+type BEDPE struct {
+	Chrom1       string
+	Start1, End1 int
+	Chrom2       string
+	Start2, End2 int
+	Name         string
+	Score        string
+	Strand1      string
+	Strand2      string
+	Fields       []string
+}
+
+// isHeader reports whether a line of a BED is a comment, track or browser line.
+func isHeader(line string) bool {
+	return line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser")
+}
+
+// eachLine calls fn with each line of r that isn't a header, with its 1-based line number.
+func eachLine(r io.Reader, fn func(line string, n int) error) error {
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if line = strings.TrimRight(line, "\r\n"); !isHeader(line) {
+			if ferr := fn(line, n); ferr != nil {
+				return ferr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func parseInterval(start, end string) (int, int, error) {
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, err
+	}
+	e, err := strconv.Atoi(end)
+	if err != nil {
+		return 0, 0, err
+	}
+	if e < s {
+		return 0, 0, fmt.Errorf("end %d is before start %d", e, s)
+	}
+	return s, e, nil
+}
+
+// ReadBED reads the records of a BED.
+func ReadBED(r io.Reader) ([]*BED, error) {
+	var bs []*BED
+	err := eachLine(r, func(line string, n int) error {
+		toks := strings.Split(line, "\t")
+		if len(toks) < 3 {
+			return fmt.Errorf("intervals: expected 3 columns on line %d", n)
+		}
+		s, e, err := parseInterval(toks[1], toks[2])
+		if err != nil {
+			return fmt.Errorf("intervals: line %d: %w", n, err)
+		}
+		bs = append(bs, &BED{Chrom: toks[0], Start: s, End: e, Fields: toks[3:]})
+		return nil
+	})
+	return bs, err
+}
+
+// ReadBEDPE reads the records of a BEDPE.
+func ReadBEDPE(r io.Reader) ([]*BEDPE, error) {
+	var bs []*BEDPE
+	err := eachLine(r, func(line string, n int) error {
+		toks := strings.Split(line, "\t")
+		if len(toks) < 6 {
+			return fmt.Errorf("intervals: expected 6 columns on line %d", n)
+		}
+		b := &BEDPE{Chrom1: toks[0], Chrom2: toks[3]}
+		var err error
+		if b.Start1, b.End1, err = parseInterval(toks[1], toks[2]); err != nil {
+			return fmt.Errorf("intervals: line %d: %w", n, err)
+		}
+		if b.Start2, b.End2, err = parseInterval(toks[4], toks[5]); err != nil {
+			return fmt.Errorf("intervals: line %d: %w", n, err)
+		}
+		for i, f := range []*string{&b.Name, &b.Score, &b.Strand1, &b.Strand2} {
+			if len(toks) > 6+i {
+				*f = toks[6+i]
+			}
+		}
+		if len(toks) > 10 {
+			b.Fields = toks[10:]
+		}
+		bs = append(bs, b)
+		return nil
+	})
+	return bs, err
+}
+
+// LoadBED indexes the records of a (possibly gzipped) BED by contig.
+func LoadBED(path string, normalize func(string) string) (*Index[*BED], error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	bs, err := ReadBED(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	x := NewIndex[*BED](normalize)
+	for _, b := range bs {
+		x.Add(b.Chrom, b.Start, b.End, b)
+	}
+	x.Index()
+	return x, nil
+}
+
+// LoadBEDSet reads the intervals of a (possibly gzipped) BED as a set, ignoring the other columns.
+func LoadBEDSet(path string, normalize func(string) string) (*SetIndex, error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	bs, err := ReadBED(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	x := NewSetIndex(normalize)
+	for _, b := range bs {
+		x.Add(b.Chrom, b.Start, b.End)
+	}
+	x.Index()
+	return x, nil
+}
+
+// PairIndex finds BEDPE records by both ends.
+type PairIndex struct {
+	ends *Index[*BEDPE]
+}
+
+// LoadBEDPE indexes the records of a (possibly gzipped) BEDPE.
+func LoadBEDPE(path string, normalize func(string) string) (*PairIndex, error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	bs, err := ReadBEDPE(rdr)
+	if err != nil {
+		return nil, fmt.Errorf("%w in %s", err, path)
+	}
+	return NewPairIndex(bs, normalize), nil
+}
+
+// NewPairIndex indexes the records by the first end.
+func NewPairIndex(bs []*BEDPE, normalize func(string) string) *PairIndex {
+	x := NewIndex[*BEDPE](normalize)
+	for _, b := range bs {
+		x.Add(b.Chrom1, b.Start1, b.End1, b)
+	}
+	x.Index()
+	return &PairIndex{ends: x}
+}
+
+// Overlapping gives the records with one end overlapping [start1, end1) on contig1 and the other
+// overlapping [start2, end2) on contig2, in either order.
+func (p *PairIndex) Overlapping(contig1 string, start1, end1 int, contig2 string, start2, end2 int) []*BEDPE {
+	var out []*BEDPE
+	seen := make(map[*BEDPE]bool)
+	k1, k2 := p.ends.key(contig1), p.ends.key(contig2)
+	for _, iv := range p.ends.Overlapping(contig1, start1, end1) {
+		b := iv.Value
+		if p.ends.key(b.Chrom2) == k2 && b.Start2 < max(end2, start2+1) && start2 < b.End2 {
+			seen[b] = true
+			out = append(out, b)
+		}
+	}
+	for _, iv := range p.ends.Overlapping(contig2, start2, end2) {
+		b := iv.Value
+		if !seen[b] && p.ends.key(b.Chrom2) == k1 && b.Start2 < max(end1, start1+1) && start1 < b.End2 {
+			out = append(out, b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,171 @@
+package intervals
+
+import (
+	"sort"
+	"strings"
+)
+
+// StripChr removes a "chr" prefix so that contigs named 1 and chr1 are the same. It can be used
+// as the Normalize function of an Index or SetIndex.
+func StripChr(contig string) string {
+	return strings.TrimPrefix(contig, "chr")
+}
+
+// Index is a Tree for each contig.
+type Index[T any] struct {
+	// Normalize, if set, maps contig names before they are added or looked up.
+	Normalize func(string) string
+	trees     map[string]*Tree[T]
+}
+
+// NewIndex returns an empty index that maps contig names with normalize, which may be nil.
+func NewIndex[T any](normalize func(string) string) *Index[T] {
+	return &Index[T]{Normalize: normalize, trees: make(map[string]*Tree[T])}
+}
+
+func (x *Index[T]) key(contig string) string {
+	if x.Normalize != nil {
+		return x.Normalize(contig)
+	}
+	return contig
+}
+
+// Add adds [start, end) on contig with value v.
+func (x *Index[T]) Add(contig string, start, end int, v T) {
+	k := x.key(contig)
+	t, ok := x.trees[k]
+	if !ok {
+		t = &Tree[T]{}
+		x.trees[k] = t
+	}
+	t.Add(start, end, v)
+}
+
+// Tree gives the tree for contig or nil if it has no intervals.
+func (x *Index[T]) Tree(contig string) *Tree[T] {
+	return x.trees[x.key(contig)]
+}
+
+// Contigs gives the (normalized) contigs with intervals, sorted.
+func (x *Index[T]) Contigs() []string {
+	cs := make([]string, 0, len(x.trees))
+	for c := range x.trees {
+		cs = append(cs, c)
+	}
+	sort.Strings(cs)
+	return cs
+}
+
+// Index builds the trees for all contigs so that the index can be queried concurrently.
+func (x *Index[T]) Index() {
+	for _, t := range x.trees {
+		t.Index()
+	}
+}
+
+// Overlapping gives the intervals on contig that overlap [start, end).
+func (x *Index[T]) Overlapping(contig string, start, end int) []Interval[T] {
+	if t := x.Tree(contig); t != nil {
+		return t.Overlapping(start, end)
+	}
+	return nil
+}
+
+// Overlaps reports whether any interval on contig overlaps [start, end).
+func (x *Index[T]) Overlaps(contig string, start, end int) bool {
+	t := x.Tree(contig)
+	return t != nil && t.Overlaps(start, end)
+}
+
+// Enclosing gives the intervals on contig that contain all of [start, end).
+func (x *Index[T]) Enclosing(contig string, start, end int) []Interval[T] {
+	if t := x.Tree(contig); t != nil {
+		return t.Enclosing(start, end)
+	}
+	return nil
+}
+
+// Within gives the intervals on contig that are contained in [start, end).
+func (x *Index[T]) Within(contig string, start, end int) []Interval[T] {
+	if t := x.Tree(contig); t != nil {
+		return t.Within(start, end)
+	}
+	return nil
+}
+
+// Nearest is Tree.Nearest for contig.
+func (x *Index[T]) Nearest(contig string, start, end int) ([]Interval[T], int, bool) {
+	if t := x.Tree(contig); t != nil {
+		return t.Nearest(start, end)
+	}
+	return nil, 0, false
+}
+
+// SetIndex is a Set for each contig.
+type SetIndex struct {
+	// Normalize, if set, maps contig names before they are added or looked up.
+	Normalize func(string) string
+	sets      map[string]*Set
+}
+
+// NewSetIndex returns an empty index that maps contig names with normalize, which may be nil.
+func NewSetIndex(normalize func(string) string) *SetIndex {
+	return &SetIndex{Normalize: normalize, sets: make(map[string]*Set)}
+}
+
+func (x *SetIndex) key(contig string) string {
+	if x.Normalize != nil {
+		return x.Normalize(contig)
+	}
+	return contig
+}
+
+// Add adds [start, end) on contig.
+func (x *SetIndex) Add(contig string, start, end int) {
+	k := x.key(contig)
+	s, ok := x.sets[k]
+	if !ok {
+		s = &Set{}
+		x.sets[k] = s
+	}
+	s.Add(start, end)
+}
+
+// Set gives the set for contig or nil if it has no intervals.
+func (x *SetIndex) Set(contig string) *Set {
+	return x.sets[x.key(contig)]
+}
+
+// Index merges the intervals of all contigs so that the index can be queried concurrently.
+func (x *SetIndex) Index() {
+	for _, s := range x.sets {
+		s.Index()
+	}
+}
+
+// Size is the number of positions in the sets of all contigs.
+func (x *SetIndex) Size() int {
+	n := 0
+	for _, s := range x.sets {
+		n += s.Size()
+	}
+	return n
+}
+
+// Overlaps reports whether any position of [start, end) on contig is in the set.
+func (x *SetIndex) Overlaps(contig string, start, end int) bool {
+	s := x.Set(contig)
+	return s != nil && s.Overlaps(start, end)
+}
+
+// Contains reports whether pos on contig is in the set.
+func (x *SetIndex) Contains(contig string, pos int) bool {
+	s := x.Set(contig)
+	return s != nil && s.Contains(pos)
+}
+
+// Encloses reports whether all of [start, end) on contig is in the set.
+func (x *SetIndex) Encloses(contig string, start, end int) bool {
+	s := x.Set(contig)
+	return s != nil && s.Encloses(start, end)
+}
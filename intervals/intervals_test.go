@@ -0,0 +1,215 @@
+package intervals
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func randomTree(rng *rand.Rand, n int) (*Tree[int], []Interval[int]) {
+	t := &Tree[int]{}
+	var ivs []Interval[int]
+	for i := 0; i < n; i++ {
+		s := rng.Intn(1000)
+		e := s + rng.Intn(100)
+		if rng.Intn(10) == 0 {
+			e = s + rng.Intn(1000)
+		}
+		t.Add(s, e, i)
+		ivs = append(ivs, Interval[int]{s, e, i})
+	}
+	return t, ivs
+}
+
+func values(ivs []Interval[int]) []int {
+	vs := []int{}
+	for _, iv := range ivs {
+		vs = append(vs, iv.Value)
+	}
+	sort.Ints(vs)
+	return vs
+}
+
+func TestTreeBruteForce(t *testing.T) {
+	rng := rand.New(rand.NewSource(42))
+	for _, n := range []int{0, 1, 2, 7, 8, 9, 31, 100, 513} {
+		tree, ivs := randomTree(rng, n)
+		for q := 0; q < 200; q++ {
+			s := rng.Intn(1200) - 100
+			e := s + rng.Intn(50)
+			qe := e
+			if qe <= s {
+				qe = s + 1
+			}
+			var over, encl, within []Interval[int]
+			for _, iv := range ivs {
+				if iv.Start < qe && s < iv.End {
+					over = append(over, iv)
+					if iv.Start <= s && e <= iv.End {
+						encl = append(encl, iv)
+					}
+				}
+				if s <= iv.Start && iv.Start < e && iv.End <= e {
+					within = append(within, iv)
+				}
+			}
+			if got, want := values(tree.Overlapping(s, e)), values(over); !reflect.DeepEqual(got, want) {
+				t.Fatalf("n=%d Overlapping(%d, %d) = %v, want %v", n, s, e, got, want)
+			}
+			if got, want := tree.Overlaps(s, e), len(over) > 0; got != want {
+				t.Fatalf("n=%d Overlaps(%d, %d) = %v, want %v", n, s, e, got, want)
+			}
+			if got, want := values(tree.Enclosing(s, e)), values(encl); !reflect.DeepEqual(got, want) {
+				t.Fatalf("n=%d Enclosing(%d, %d) = %v, want %v", n, s, e, got, want)
+			}
+			if got, want := values(tree.Within(s, e)), values(within); !reflect.DeepEqual(got, want) {
+				t.Fatalf("n=%d Within(%d, %d) = %v, want %v", n, s, e, got, want)
+			}
+
+			best := -1
+			for _, iv := range ivs {
+				d := 0
+				if iv.End <= s {
+					d = s - iv.End + 1
+				} else if qe <= iv.Start {
+					d = iv.Start - qe + 1
+				}
+				if best < 0 || d < best {
+					best = d
+				}
+			}
+			near, d, ok := tree.Nearest(s, e)
+			if ok != (n > 0) || (ok && d != best) {
+				t.Fatalf("n=%d Nearest(%d, %d) = %d, %v, want %d", n, s, e, d, ok, best)
+			}
+			for _, iv := range near {
+				if d == 0 && !(iv.Start < qe && s < iv.End) || d > 0 && iv.End != s-d+1 && iv.Start != qe+d-1 {
+					t.Fatalf("n=%d Nearest(%d, %d) gave %v at distance %d", n, s, e, iv, d)
+				}
+			}
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := &Set{}
+	for _, iv := range [][2]int{{50, 60}, {10, 20}, {15, 30}, {30, 35}, {100, 200}} {
+		s.Add(iv[0], iv[1])
+	}
+	if s.Len() != 3 || s.Size() != 25+10+100 {
+		t.Fatalf("got %d intervals of size %d", s.Len(), s.Size())
+	}
+	cases := []struct {
+		s, e            int
+		overlaps, encl  bool
+		nearestStart, d int
+	}{
+		{0, 10, false, false, 10, 1},
+		{0, 11, true, false, 10, 0},
+		{12, 34, true, true, 10, 0},
+		{34, 36, true, false, 10, 0},
+		{35, 40, false, false, 10, 1},
+		{45, 48, false, false, 50, 3},
+		{70, 71, false, false, 50, 11},
+		{150, 150, true, true, 100, 0},
+		{300, 310, false, false, 100, 101},
+	}
+	for _, c := range cases {
+		if got := s.Overlaps(c.s, c.e); got != c.overlaps {
+			t.Errorf("Overlaps(%d, %d) = %v", c.s, c.e, got)
+		}
+		if got := s.Encloses(c.s, c.e); got != c.encl {
+			t.Errorf("Encloses(%d, %d) = %v", c.s, c.e, got)
+		}
+		iv, d, ok := s.Nearest(c.s, c.e)
+		if !ok || iv.Start != c.nearestStart || d != c.d {
+			t.Errorf("Nearest(%d, %d) = %v, %d", c.s, c.e, iv, d)
+		}
+	}
+	if s.Contains(9) || !s.Contains(10) || s.Contains(35) {
+		t.Error("Contains is wrong at the ends of [10, 35)")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	x := NewIndex[string](StripChr)
+	x.Add("chr1", 10, 20, "a")
+	x.Add("1", 15, 25, "b")
+	x.Add("chr2", 10, 20, "c")
+	if got := x.Contigs(); !reflect.DeepEqual(got, []string{"1", "2"}) {
+		t.Fatalf("Contigs() = %v", got)
+	}
+	var names []string
+	for _, iv := range x.Overlapping("1", 18, 19) {
+		names = append(names, iv.Value)
+	}
+	if !reflect.DeepEqual(names, []string{"a", "b"}) {
+		t.Errorf("Overlapping = %v", names)
+	}
+	if x.Overlaps("chr3", 0, 100) || !x.Overlaps("chr2", 19, 30) {
+		t.Error("Overlaps is wrong")
+	}
+	if _, _, ok := x.Nearest("X", 0, 1); ok {
+		t.Error("Nearest on a contig without intervals is ok")
+	}
+}
+
+const testBED = `track name=test
+# comment
+chr1	10	20	a	0	+
+chr1	15	30
+chr2	5	6	c
+`
+
+const testBEDPE = `#chrom1	start1	end1	chrom2	start2	end2
+chr1	100	200	chr2	500	600	del1	10	+	-	extra
+chr1	1000	1010	chr1	5000	5010
+`
+
+func TestReadBED(t *testing.T) {
+	bs, err := ReadBED(strings.NewReader(testBED))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 3 || bs[0].Name() != "a" || len(bs[0].Fields) != 3 || bs[1].Name() != "" || bs[2].End != 6 {
+		t.Fatalf("unexpected records: %+v", bs)
+	}
+	for _, bad := range []string{"chr1\t10\n", "chr1\tx\t20\n", "chr1\t20\t10\n"} {
+		if _, err := ReadBED(strings.NewReader(bad)); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestReadBEDPE(t *testing.T) {
+	bs, err := ReadBEDPE(strings.NewReader(testBEDPE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 2 {
+		t.Fatalf("got %d records", len(bs))
+	}
+	want := &BEDPE{"chr1", 100, 200, "chr2", 500, 600, "del1", "10", "+", "-", []string{"extra"}}
+	if !reflect.DeepEqual(bs[0], want) {
+		t.Errorf("got %+v, want %+v", bs[0], want)
+	}
+	if bs[1].Name != "" || bs[1].Strand1 != "" {
+		t.Errorf("optional columns of %+v are set", bs[1])
+	}
+
+	p := NewPairIndex(bs, StripChr)
+	if got := p.Overlapping("1", 150, 151, "2", 599, 700); len(got) != 1 || got[0] != bs[0] {
+		t.Errorf("Overlapping gave %v", got)
+	}
+	if got := p.Overlapping("2", 599, 700, "chr1", 150, 151); len(got) != 1 || got[0] != bs[0] {
+		t.Errorf("Overlapping with the ends swapped gave %v", got)
+	}
+	if got := p.Overlapping("1", 150, 151, "2", 600, 700); len(got) != 0 {
+		t.Errorf("Overlapping past the second end gave %v", got)
+	}
+	if got := p.Overlapping("1", 1005, 1006, "1", 5000, 5001); len(got) != 1 || got[0] != bs[1] {
+		t.Errorf("Overlapping on one contig gave %v", got)
+	}
+}
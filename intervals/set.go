@@ -0,0 +1,108 @@
+package intervals
+
+import "sort"
+
+// Set is a set of positions stored as sorted, merged intervals. Overlapping and adjacent
+// intervals are merged when the set is indexed, so a lookup is a binary search.
+type Set struct {
+	starts, ends []int
+	indexed      bool
+}
+
+// Add adds [start, end) to the set.
+func (s *Set) Add(start, end int) {
+	s.starts = append(s.starts, start)
+	s.ends = append(s.ends, end)
+	s.indexed = false
+}
+
+// Index sorts and merges the intervals. It is called by the queries when intervals have been
+// added, but calling it first makes concurrent queries safe.
+func (s *Set) Index() {
+	if s.indexed {
+		return
+	}
+	ivs := make([][2]int, len(s.starts))
+	for i := range s.starts {
+		ivs[i] = [2]int{s.starts[i], s.ends[i]}
+	}
+	sort.Slice(ivs, func(i, j int) bool { return ivs[i][0] < ivs[j][0] })
+	s.starts, s.ends = s.starts[:0], s.ends[:0]
+	for _, v := range ivs {
+		if n := len(s.ends); n > 0 && v[0] <= s.ends[n-1] {
+			s.ends[n-1] = max(s.ends[n-1], v[1])
+			continue
+		}
+		s.starts, s.ends = append(s.starts, v[0]), append(s.ends, v[1])
+	}
+	s.indexed = true
+}
+
+// Len is the number of merged intervals.
+func (s *Set) Len() int {
+	s.Index()
+	return len(s.starts)
+}
+
+// Size is the number of positions in the set.
+func (s *Set) Size() int {
+	s.Index()
+	n := 0
+	for i := range s.starts {
+		n += s.ends[i] - s.starts[i]
+	}
+	return n
+}
+
+// find gives the index of the first merged interval that ends after pos.
+func (s *Set) find(pos int) int {
+	s.Index()
+	return sort.SearchInts(s.ends, pos+1)
+}
+
+// Overlaps reports whether any position in [start, end) is in the set. An empty query interval is
+// treated as the single position start.
+func (s *Set) Overlaps(start, end int) bool {
+	if end <= start {
+		end = start + 1
+	}
+	i := s.find(start)
+	return i < len(s.starts) && s.starts[i] < end
+}
+
+// Contains reports whether pos is in the set.
+func (s *Set) Contains(pos int) bool {
+	return s.Overlaps(pos, pos+1)
+}
+
+// Encloses reports whether all of [start, end) is in the set.
+func (s *Set) Encloses(start, end int) bool {
+	i := s.find(start)
+	return i < len(s.starts) && s.starts[i] <= start && end <= s.ends[i]
+}
+
+// Nearest gives the merged interval that overlaps [start, end) or is closest to it, with its
+// distance as for Tree.Nearest. ok is false if the set is empty.
+func (s *Set) Nearest(start, end int) (iv Interval[struct{}], distance int, ok bool) {
+	if end <= start {
+		end = start + 1
+	}
+	i := s.find(start)
+	n := len(s.starts)
+	if n == 0 {
+		return iv, 0, false
+	}
+	if i < n && s.starts[i] < end {
+		return Interval[struct{}]{Start: s.starts[i], End: s.ends[i]}, 0, true
+	}
+	distance = -1
+	if i > 0 {
+		iv, distance = Interval[struct{}]{Start: s.starts[i-1], End: s.ends[i-1]}, start-s.ends[i-1]+1
+	}
+	if i < n {
+		if d := s.starts[i] - end + 1; distance < 0 || d < distance {
+			iv, distance = Interval[struct{}]{Start: s.starts[i], End: s.ends[i]}, d
+		}
+	}
+	return iv, distance, true
+}
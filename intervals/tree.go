@@ -0,0 +1,231 @@
+// Package intervals indexes genomic intervals for overlap, containment and nearest-neighbour
+// queries.
+//
+// Tree is an augmented interval tree for intervals that carry a value and may overlap. Set is a
+// sorted array of merged intervals for when only membership matters, such as a blacklist.
+// Index and SetIndex key these by contig. All intervals are 0-based and half-open.
+package intervals
+
+import "sort"
+
+// Interval is [Start, End) with a value.
+type Interval[T any] struct {
+	Start, End int
+	Value      T
+}
+
+// Tree is an augmented interval tree. It is stored as an implicit binary tree over the intervals
+// sorted by start, where each node also has the largest end in its subtree (as in cgranges).
+// Intervals can be added at any time; the tree is rebuilt on the next query.
+type Tree[T any] struct {
+	ivs    []Interval[T]
+	maxEnd []int
+	// prefix[i] is the index of the interval with the largest end among ivs[:i+1].
+	prefix  []int
+	levels  int
+	indexed bool
+}
+
+// Add adds [start, end) with value v.
+func (t *Tree[T]) Add(start, end int, v T) {
+	t.ivs = append(t.ivs, Interval[T]{start, end, v})
+	t.indexed = false
+}
+
+// Len is the number of intervals in the tree.
+func (t *Tree[T]) Len() int {
+	return len(t.ivs)
+}
+
+// Index builds the tree. It is called by the queries when intervals have been added, but calling
+// it first makes concurrent queries safe.
+func (t *Tree[T]) Index() {
+	if t.indexed {
+		return
+	}
+	ivs := t.ivs
+	sort.SliceStable(ivs, func(i, j int) bool {
+		if ivs[i].Start != ivs[j].Start {
+			return ivs[i].Start < ivs[j].Start
+		}
+		return ivs[i].End < ivs[j].End
+	})
+	n := len(ivs)
+	t.maxEnd = make([]int, n)
+	t.prefix = make([]int, n)
+	for i := range ivs {
+		t.maxEnd[i] = ivs[i].End
+		t.prefix[i] = i
+		if i > 0 && ivs[t.prefix[i-1]].End >= ivs[i].End {
+			t.prefix[i] = t.prefix[i-1]
+		}
+	}
+	t.levels = 0
+	if n == 0 {
+		t.indexed = true
+		return
+	}
+	// nodes at level k are at indexes with k trailing ones. lastI and last track the right-most
+	// node at each level, whose right subtree may be incomplete.
+	lastI, last := 0, 0
+	for i := 0; i < n; i += 2 {
+		lastI, last = i, ivs[i].End
+	}
+	k := 1
+	for ; 1<<uint(k) <= n; k++ {
+		x := 1 << uint(k-1)
+		for i := x<<1 - 1; i < n; i += x << 2 {
+			e := max(ivs[i].End, t.maxEnd[i-x])
+			if i+x < n {
+				e = max(e, t.maxEnd[i+x])
+			} else {
+				e = max(e, last)
+			}
+			t.maxEnd[i] = e
+		}
+		if lastI>>uint(k)&1 != 0 {
+			lastI -= x
+		} else {
+			lastI += x
+		}
+		if lastI < n && t.maxEnd[lastI] > last {
+			last = t.maxEnd[lastI]
+		}
+	}
+	t.levels = k - 1
+	t.indexed = true
+}
+
+// each calls fn with the index of each interval that overlaps [start, end). An empty query
+// interval is treated as the single position start.
+func (t *Tree[T]) each(start, end int, fn func(i int)) {
+	t.Index()
+	n := len(t.ivs)
+	if n == 0 {
+		return
+	}
+	if end <= start {
+		end = start + 1
+	}
+	type node struct {
+		x, k int
+		// w is true once the left child has been visited.
+		w bool
+	}
+	stack := make([]node, 0, 64)
+	stack = append(stack, node{1<<uint(t.levels) - 1, t.levels, false})
+	for len(stack) > 0 {
+		z := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch {
+		case z.k <= 3:
+			// small subtrees are scanned.
+			i0 := z.x >> uint(z.k) << uint(z.k)
+			i1 := min(i0+1<<uint(z.k+1)-1, n)
+			for i := i0; i < i1 && t.ivs[i].Start < end; i++ {
+				if start < t.ivs[i].End {
+					fn(i)
+				}
+			}
+		case !z.w:
+			stack = append(stack, node{z.x, z.k, true})
+			if y := z.x - 1<<uint(z.k-1); y >= n || t.maxEnd[y] > start {
+				stack = append(stack, node{y, z.k - 1, false})
+			}
+		case z.x < n && t.ivs[z.x].Start < end:
+			if start < t.ivs[z.x].End {
+				fn(z.x)
+			}
+			stack = append(stack, node{z.x + 1<<uint(z.k-1), z.k - 1, false})
+		}
+	}
+}
+
+// Overlapping gives the intervals that overlap [start, end), sorted by start.
+func (t *Tree[T]) Overlapping(start, end int) []Interval[T] {
+	var idx []int
+	t.each(start, end, func(i int) { idx = append(idx, i) })
+	sort.Ints(idx)
+	out := make([]Interval[T], len(idx))
+	for j, i := range idx {
+		out[j] = t.ivs[i]
+	}
+	return out
+}
+
+// Overlaps reports whether any interval overlaps [start, end).
+func (t *Tree[T]) Overlaps(start, end int) bool {
+	found := false
+	t.each(start, end, func(int) { found = true })
+	return found
+}
+
+// Enclosing gives the intervals that contain all of [start, end).
+func (t *Tree[T]) Enclosing(start, end int) []Interval[T] {
+	var out []Interval[T]
+	for _, iv := range t.Overlapping(start, end) {
+		if iv.Start <= start && end <= iv.End {
+			out = append(out, iv)
+		}
+	}
+	return out
+}
+
+// Within gives the intervals that are contained in [start, end).
+func (t *Tree[T]) Within(start, end int) []Interval[T] {
+	t.Index()
+	var out []Interval[T]
+	i := sort.Search(len(t.ivs), func(i int) bool { return t.ivs[i].Start >= start })
+	for ; i < len(t.ivs) && t.ivs[i].Start < end; i++ {
+		if t.ivs[i].End <= end {
+			out = append(out, t.ivs[i])
+		}
+	}
+	return out
+}
+
+// Nearest gives the intervals that overlap [start, end) or, if there are none, the closest
+// interval on either side, with its distance (0 for overlaps). ok is false if the tree is empty.
+func (t *Tree[T]) Nearest(start, end int) (ivs []Interval[T], distance int, ok bool) {
+	if ivs = t.Overlapping(start, end); len(ivs) > 0 {
+		return ivs, 0, true
+	}
+	n := len(t.ivs)
+	if n == 0 {
+		return nil, 0, false
+	}
+	if end <= start {
+		end = start + 1
+	}
+	// the closest interval on the right starts first at or after end. on the left it is the one
+	// with the largest end of those that start before start.
+	r := sort.Search(n, func(i int) bool { return t.ivs[i].Start >= end })
+	l := sort.Search(n, func(i int) bool { return t.ivs[i].Start >= start }) - 1
+	distance = -1
+	if l >= 0 {
+		iv := t.ivs[t.prefix[l]]
+		ivs, distance = []Interval[T]{iv}, start-iv.End+1
+	}
+	if r < n {
+		if d := t.ivs[r].Start - end + 1; distance < 0 || d < distance {
+			ivs, distance = []Interval[T]{t.ivs[r]}, d
+		} else if d == distance {
+			ivs = append(ivs, t.ivs[r])
+		}
+	}
+	return ivs, distance, true
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -22,14 +22,42 @@ package main
 
 import (
 	"fmt"
+	"github.com/Schaudge/ngsutils/annotate"
+	"github.com/Schaudge/ngsutils/assembly"
 	"github.com/Schaudge/ngsutils/db"
+	"github.com/Schaudge/ngsutils/extract"
+	"github.com/Schaudge/ngsutils/gsort/sortcmd"
+	"github.com/Schaudge/ngsutils/insertsize"
+	"github.com/Schaudge/ngsutils/rename"
 	"github.com/Schaudge/ngsutils/stats"
 	"os"
 )
 
+// subcommands parse their own arguments from os.Args with the sub-command name removed.
+var subcommands = map[string]func(){
+	"annotate": annotate.Main,
+	"excord":   extract.SvReads,
+	"genotype": extract.Genotype,
+	"insert":   insertsize.Main,
+	"rename":   rename.Main,
+	"sort":     sortcmd.Main,
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			cmd()
+			return
+		}
+	}
 
 	accession, bam := os.Args[1], os.Args[2]
+	// with a fasta, the reads around each break point are also assembled into junctions.
+	var fasta string
+	if len(os.Args) > 3 {
+		fasta = os.Args[3]
+	}
 
 	fmt.Println("Begin to start some ngs-utils process:")
 	svbps := db.GetSvRecordsFromDB(accession)
@@ -41,6 +69,16 @@ func main() {
 		if err != nil {
 			return
 		}
+		if fasta != "" {
+			junctions, err := stats.AssembleSvJunctions(bam, fasta, sv)
+			if err != nil {
+				return
+			}
+			fmt.Println(assembly.JunctionHeader)
+			for _, j := range junctions {
+				fmt.Println(j)
+			}
+		}
 	}
 
 }
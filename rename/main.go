@@ -0,0 +1,122 @@
+package rename
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/utils"
+	arg "github.com/alexflint/go-arg"
+	"github.com/biogo/hts/bam"
+)
+
+type cliarg struct {
+	Map     string `arg:"-m,required,help:file mapping contig names in the --from column to the --to column"`
+	From    string `arg:"help:column of the map with the names in the input: a number from 1 or a name in its # header. default 1"`
+	To      string `arg:"help:column of the map with the names to write: a number from 1 or a name in its # header. default 2"`
+	Preset  string `arg:"-p,help:format of the input: bed or bedpe or vcf or gff or gtf or sam or bam. guessed from the file name if not given"`
+	Columns []int  `arg:"-k,--column,separate,help:column of the input with contig names counting from 1. repeat for several. overrides the columns of --preset"`
+	Strict  bool   `arg:"help:fail on a contig that is not in the map instead of keeping its name with a warning"`
+	Input   string `arg:"positional,help:file to rename. reads stdin if not given"`
+}
+
+// presetFromPath gives the format for the extension of path, ignoring a .gz or .bgz suffix.
+func presetFromPath(path string) string {
+	path = strings.TrimSuffix(strings.TrimSuffix(path, ".gz"), ".bgz")
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(path), "."))
+	if ext == "gff3" {
+		ext = "gff"
+	}
+	return ext
+}
+
+// renameBAM writes the BAM from r to w with the references in its header renamed.
+func renameBAM(w io.Writer, r io.Reader, m *Map) error {
+	br, err := bam.NewReader(r, 1)
+	if err != nil {
+		return err
+	}
+	defer br.Close()
+	h, err := Header(br.Header(), m)
+	if err != nil {
+		return err
+	}
+	bw, err := bam.NewWriter(w, h, 1)
+	if err != nil {
+		return err
+	}
+	for {
+		rec, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := bw.Write(rec); err != nil {
+			return err
+		}
+	}
+	return bw.Close()
+}
+
+// Main is the entry-point for the rename sub-command. It renames the contigs of a BED, BEDPE,
+// VCF, GFF, GTF, SAM or BAM, or of the given columns of other tab-delimited files, with a mapping
+// file such as a chromAlias.txt from UCSC.
+func Main() {
+	cli := &cliarg{Input: "-"}
+	p := arg.MustParse(cli)
+	preset := strings.ToLower(cli.Preset)
+	if preset == "" {
+		preset = presetFromPath(cli.Input)
+	}
+	columns, ok := Presets[preset]
+	if len(cli.Columns) > 0 {
+		columns = cli.Columns
+	} else if !ok && preset != "bam" {
+		if cli.Preset != "" {
+			p.Fail("unknown --preset " + cli.Preset)
+		}
+		columns = []int{1}
+	}
+	mode := Warn
+	if cli.Strict {
+		mode = Strict
+	}
+	m, err := ReadMap(cli.Map, cli.From, cli.To, mode)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if preset == "bam" {
+		var r io.Reader = os.Stdin
+		if cli.Input != "-" {
+			f, err := os.Open(cli.Input)
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer f.Close()
+			r = f
+		}
+		if err := renameBAM(os.Stdout, bufio.NewReader(r), m); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var rdr io.Reader = os.Stdin
+	if cli.Input != "-" {
+		f, err := utils.Ropen(cli.Input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		rdr = f
+	}
+	if err := NewColumns(m, columns...).Copy(os.Stdout, rdr); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,324 @@
+// Package rename maps contig names between naming schemes, such as UCSC (chr1), Ensembl (1) and
+// RefSeq (NC_000001.11), in tab-delimited lines, their headers and BAM headers.
+package rename
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Schaudge/ngsutils/utils"
+	"github.com/biogo/hts/sam"
+)
+
+// Mode decides what happens to a contig that is not in a Map.
+type Mode int
+
+const (
+	// Warn keeps the name of a missing contig and logs a warning the first time it is seen.
+	Warn Mode = iota
+	// Strict makes a missing contig an error.
+	Strict
+)
+
+// Map renames contigs.
+type Map struct {
+	Mode  Mode
+	names map[string]string
+
+	mu     sync.Mutex
+	warned map[string]bool
+}
+
+// NewMap renames the keys of names to their values.
+func NewMap(names map[string]string, mode Mode) *Map {
+	return &Map{Mode: mode, names: names}
+}
+
+// Len is the number of contigs that are renamed.
+func (m *Map) Len() int {
+	return len(m.names)
+}
+
+// Rename gives the new name of contig. Missing contigs keep their name in Warn mode.
+func (m *Map) Rename(contig string) (string, error) {
+	if to, ok := m.names[contig]; ok {
+		return to, nil
+	}
+	if m.Mode == Strict {
+		return contig, fmt.Errorf("rename: no mapping for contig %s", contig)
+	}
+	m.mu.Lock()
+	if !m.warned[contig] {
+		if m.warned == nil {
+			m.warned = make(map[string]bool)
+		}
+		m.warned[contig] = true
+		log.Printf("[rename] WARNING: could not find mapping for chromosome: %s", contig)
+	}
+	m.mu.Unlock()
+	return contig, nil
+}
+
+// ParseMap reads the names in the from and to columns of a mapping file. Columns are separated by
+// tabs or, if a line has no tabs, by spaces. A column is given by its number, starting at 1, or by
+// its name in a first line that starts with '#', as in the chromAlias.txt files of UCSC that have
+// ucsc, assembly, genbank, refseq and ensembl columns. Empty from and to default to the first and
+// second columns. Other lines that start with '#' and lines with an empty from or to are skipped.
+func ParseMap(r io.Reader, from, to string) (map[string]string, error) {
+	if from == "" {
+		from = "1"
+	}
+	if to == "" {
+		to = "2"
+	}
+	var fi, ti int = -1, -1
+	names := make(map[string]string)
+	brdr := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := brdr.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if fi < 0 {
+			var header []string
+			if strings.HasPrefix(line, "#") {
+				header = splitColumns(strings.TrimLeft(line, "# "))
+			}
+			if fi, err = column(from, header); err == nil {
+				ti, err = column(to, header)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if line != "" && line[0] != '#' {
+			toks := splitColumns(line)
+			if fi < len(toks) && ti < len(toks) && toks[fi] != "" && toks[ti] != "" {
+				if old, ok := names[toks[fi]]; ok && old != toks[ti] {
+					return nil, fmt.Errorf("rename: %s is mapped to both %s and %s on line %d", toks[fi], old, toks[ti], n)
+				}
+				names[toks[fi]] = toks[ti]
+			}
+		}
+		if err == io.EOF {
+			return names, nil
+		}
+	}
+}
+
+func splitColumns(line string) []string {
+	if strings.IndexByte(line, '\t') >= 0 {
+		return strings.Split(line, "\t")
+	}
+	return strings.Fields(line)
+}
+
+// column finds the 0-based index of a column given by number or by name in header.
+func column(c string, header []string) (int, error) {
+	if i, err := strconv.Atoi(c); err == nil {
+		if i < 1 {
+			return 0, fmt.Errorf("rename: bad column %d", i)
+		}
+		return i - 1, nil
+	}
+	for i, h := range header {
+		if h == c {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("rename: no column %q in the header %q", c, strings.Join(header, " "))
+}
+
+// ReadMap reads a Map from the from and to columns of the mapping file at path, as for ParseMap.
+func ReadMap(path, from, to string, mode Mode) (*Map, error) {
+	rdr, err := utils.Ropen(path)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	names, err := ParseMap(rdr, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("rename: error reading %s: %w", path, err)
+	}
+	return NewMap(names, mode), nil
+}
+
+// Columns renames the contigs in some columns of tab-delimited lines.
+type Columns struct {
+	*Map
+	// Columns has the numbers of the columns with contig names, starting at 1.
+	Columns []int
+}
+
+// Presets has the columns with contig names for the formats that gsort sorts.
+var Presets = map[string][]int{
+	"bed":   {1},
+	"bedpe": {1, 4},
+	"vcf":   {1},
+	"gff":   {1},
+	"gtf":   {1},
+	"sam":   {3, 7},
+}
+
+// NewColumns renames the contigs in the given columns with m.
+func NewColumns(m *Map, columns ...int) *Columns {
+	return &Columns{Map: m, Columns: columns}
+}
+
+// missing is a contig column without a value, as "*" and "=" in SAM.
+func missing(name []byte) bool {
+	return len(name) == 0 || len(name) == 1 && (name[0] == '*' || name[0] == '=' || name[0] == '.')
+}
+
+// Line renames the contigs in line. It returns line itself if no name changed.
+func (c *Columns) Line(line []byte) ([]byte, error) {
+	last := 0
+	for _, k := range c.Columns {
+		if k > last {
+			last = k
+		}
+	}
+	var out []byte
+	// rest is the part of line after the columns that are in out.
+	rest := 0
+	for start, col := 0, 1; col <= last; col++ {
+		end := bytes.IndexByte(line[start:], '\t')
+		if end < 0 {
+			end = len(bytes.TrimRight(line, "\r\n"))
+			if end < start {
+				end = start
+			}
+		} else {
+			end += start
+		}
+		if c.renames(col) && !missing(line[start:end]) {
+			name := string(line[start:end])
+			to, err := c.Rename(name)
+			if err != nil {
+				return line, err
+			}
+			if to != name {
+				if out == nil {
+					out = make([]byte, 0, len(line)+len(to)-len(name))
+				}
+				out = append(append(out, line[rest:start]...), to...)
+				rest = end
+			}
+		}
+		if end >= len(line) || line[end] != '\t' {
+			break
+		}
+		start = end + 1
+	}
+	if out == nil {
+		return line, nil
+	}
+	return append(out, line[rest:]...), nil
+}
+
+func (c *Columns) renames(col int) bool {
+	for _, k := range c.Columns {
+		if k == col {
+			return true
+		}
+	}
+	return false
+}
+
+// HeaderLine renames the contig in the ##contig line of a VCF header or the @SQ line of a SAM
+// header. Other lines are returned as they are.
+func (c *Columns) HeaderLine(line []byte) ([]byte, error) {
+	var start int
+	switch {
+	case bytes.HasPrefix(line, []byte("##contig=<")):
+		i := bytes.Index(line, []byte("<ID="))
+		if i < 0 {
+			if i = bytes.Index(line, []byte(",ID=")); i < 0 {
+				return line, nil
+			}
+		}
+		start = i + 4
+	case bytes.HasPrefix(line, []byte("@SQ\t")):
+		i := bytes.Index(line, []byte("\tSN:"))
+		if i < 0 {
+			return line, nil
+		}
+		start = i + 4
+	default:
+		return line, nil
+	}
+	end := start
+	for end < len(line) && bytes.IndexByte([]byte(",>\t\r\n"), line[end]) < 0 {
+		end++
+	}
+	name := string(line[start:end])
+	to, err := c.Rename(name)
+	if err != nil || to == name {
+		return line, err
+	}
+	out := make([]byte, 0, len(line)+len(to)-len(name))
+	return append(append(append(out, line[:start]...), to...), line[end:]...), nil
+}
+
+// Copy renames the contigs of the lines of r and writes them to w. Lines that start with '#' or
+// '@' are header lines that are renamed with HeaderLine.
+func (c *Columns) Copy(w io.Writer, r io.Reader) error {
+	brdr, bwtr := bufio.NewReader(r), bufio.NewWriter(w)
+	for {
+		line, err := brdr.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if len(line) > 0 {
+			var rerr error
+			if line[0] == '#' || line[0] == '@' {
+				line, rerr = c.HeaderLine(line)
+			} else {
+				line, rerr = c.Line(line)
+			}
+			if rerr != nil {
+				return rerr
+			}
+			if _, werr := bwtr.Write(line); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			return bwtr.Flush()
+		}
+	}
+}
+
+// Header returns a copy of h with its references renamed. Records that refer to the references of
+// h can be written with it, since references are written by their index.
+func Header(h *sam.Header, m *Map) (*sam.Header, error) {
+	c := h.Clone()
+	refs := c.Refs()
+	names := make([]string, len(refs))
+	for i, r := range refs {
+		to, err := m.Rename(r.Name())
+		if err != nil {
+			return nil, err
+		}
+		names[i] = to
+	}
+	// the references first get names that can't clash so that names can be swapped.
+	for i, r := range refs {
+		if err := r.SetName(fmt.Sprintf("\x00%d", i)); err != nil {
+			return nil, err
+		}
+	}
+	for i, r := range refs {
+		if err := r.SetName(names[i]); err != nil {
+			return nil, fmt.Errorf("rename: more than one reference is renamed to %s", names[i])
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,140 @@
+package rename
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/biogo/hts/sam"
+)
+
+func TestParseMap(t *testing.T) {
+	names, err := ParseMap(strings.NewReader("# a comment\n1 chr1\n2\tchr2\n\nMT chrM extra\nX\n"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"1": "chr1", "2": "chr2", "MT": "chrM"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ParseMap() = %v, want %v", names, want)
+	}
+
+	alias := "# ucsc\tassembly\tgenbank\trefseq\tensembl\n" +
+		"chr1\t1\tCM000663.2\tNC_000001.11\t1\n" +
+		"chrM\tMT\tJ01415.2\tNC_012920.1\t\n"
+	names, err = ParseMap(strings.NewReader(alias), "refseq", "ucsc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"NC_000001.11": "chr1", "NC_012920.1": "chrM"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ParseMap(refseq, ucsc) = %v, want %v", names, want)
+	}
+	// empty columns are skipped.
+	names, err = ParseMap(strings.NewReader(alias), "ucsc", "5")
+	if err != nil || len(names) != 1 || names["chr1"] != "1" {
+		t.Fatalf("ParseMap(ucsc, 5) = %v, %v", names, err)
+	}
+
+	if _, err := ParseMap(strings.NewReader(alias), "ucsc", "gencode"); err == nil {
+		t.Fatal("expected an error for a missing column")
+	}
+	if _, err := ParseMap(strings.NewReader("1 chr1\n1 chrX\n"), "", ""); err == nil {
+		t.Fatal("expected an error for a contig with two names")
+	}
+}
+
+func TestColumnsLine(t *testing.T) {
+	m := NewMap(map[string]string{"1": "chr1", "2": "chr2"}, Warn)
+	bedpe := NewColumns(m, Presets["bedpe"]...)
+	for _, tc := range []struct{ in, want string }{
+		{"1\t10\t20\t2\t30\t40\tname\n", "chr1\t10\t20\tchr2\t30\t40\tname\n"},
+		{"1\t10\t20\tGL000220.1\t30\t40\n", "chr1\t10\t20\tGL000220.1\t30\t40\n"},
+		{"2\t10\t20\t1", "chr2\t10\t20\tchr1"},
+		{"2\r\n", "chr2\r\n"},
+		{"X\t1\t2\t.\t3\t4\n", "X\t1\t2\t.\t3\t4\n"},
+		{"\n", "\n"},
+	} {
+		got, err := bedpe.Line([]byte(tc.in))
+		if err != nil || string(got) != tc.want {
+			t.Errorf("Line(%q) = %q, %v, want %q", tc.in, got, err, tc.want)
+		}
+	}
+
+	sam := NewColumns(m, Presets["sam"]...)
+	for in, want := range map[string]string{
+		"r\t0\t1\t5\t60\t4M\t=\t9\t0\tACGT\t*\n": "r\t0\tchr1\t5\t60\t4M\t=\t9\t0\tACGT\t*\n",
+		"r\t0\t1\t5\t60\t4M\t2\t9\t0\tACGT\t*\n": "r\t0\tchr1\t5\t60\t4M\tchr2\t9\t0\tACGT\t*\n",
+		"r\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n":   "r\t4\t*\t0\t0\t*\t*\t0\t0\tACGT\t*\n",
+	} {
+		got, err := sam.Line([]byte(in))
+		if err != nil || string(got) != want {
+			t.Errorf("Line(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+
+	strict := NewColumns(NewMap(map[string]string{"1": "chr1"}, Strict), 1, 4)
+	if _, err := strict.Line([]byte("1\t10\t20\tX\t30\t40\n")); err == nil {
+		t.Fatal("expected an error for a missing contig in strict mode")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	m := NewMap(map[string]string{"chr1": "1", "chr2": "2"}, Warn)
+	in := "##fileformat=VCFv4.2\n" +
+		"##contig=<ID=chr1,length=248956422>\n" +
+		"##contig=<length=242193529,ID=chr2>\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\n" +
+		"chr1\t5\t.\tA\tC\n" +
+		"chr2\t9\t.\tG\tT\n"
+	want := strings.NewReplacer("ID=chr", "ID=", "\nchr", "\n").Replace(in)
+	var out bytes.Buffer
+	if err := NewColumns(m, Presets["vcf"]...).Copy(&out, strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Fatalf("Copy() = %q, want %q", out.String(), want)
+	}
+
+	line, err := NewColumns(m).HeaderLine([]byte("@SQ\tSN:chr2\tLN:242193529\n"))
+	if err != nil || string(line) != "@SQ\tSN:2\tLN:242193529\n" {
+		t.Fatalf("HeaderLine() = %q, %v", line, err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	var refs []*sam.Reference
+	for _, name := range []string{"1", "chr1", "2"} {
+		r, err := sam.NewReference(name, "", "", 1000, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, r)
+	}
+	h, err := sam.NewHeader(nil, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names can be swapped.
+	m := NewMap(map[string]string{"1": "chr1", "chr1": "1", "2": "chr2"}, Strict)
+	c, err := Header(h, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range c.Refs() {
+		names = append(names, r.Name())
+	}
+	if want := []string{"chr1", "1", "chr2"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("renamed references are %v, want %v", names, want)
+	}
+	if h.Refs()[0].Name() != "1" {
+		t.Fatal("the original header was changed")
+	}
+
+	if _, err := Header(h, NewMap(map[string]string{"1": "chr1"}, Warn)); err == nil {
+		t.Fatal("expected an error for two references with the same name")
+	}
+	if _, err := Header(h, NewMap(map[string]string{"1": "x", "chr1": "y"}, Strict)); err == nil {
+		t.Fatal("expected an error for a missing reference in strict mode")
+	}
+}
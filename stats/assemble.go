@@ -0,0 +1,120 @@
+// Copyright © 2022 Schaudge King <yuanshenran@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package stats
+
+import (
+	"os"
+	"strings"
+
+	"github.com/Schaudge/ngsutils/assembly"
+	"github.com/Schaudge/ngsutils/db"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/faidx"
+)
+
+// minAssemblyClip is the shortest soft-clip for which a read is used in the assembly.
+const minAssemblyClip = 5
+
+// isBreakPointRead reports whether r may carry sequence from across the junction: it is
+// soft-clipped, has a supplementary alignment or its mate is mapped near the other break point.
+func isBreakPointRead(r *sam.Record, mateID, matePos, window int) bool {
+	if r.Flags&(sam.Unmapped|sam.Secondary|sam.Duplicate|sam.QCFail) != 0 {
+		return false
+	}
+	if r.MateRef.ID() == mateID && matePos-window < r.MatePos && r.MatePos < matePos+window {
+		return true
+	}
+	if _, ok := r.Tag([]byte{'S', 'A'}); ok {
+		return true
+	}
+	for _, c := range []sam.CigarOp{r.Cigar[0], r.Cigar[len(r.Cigar)-1]} {
+		if c.Type() == sam.CigarSoftClipped && c.Len() >= minAssemblyClip {
+			return true
+		}
+	}
+	return false
+}
+
+// refWindow gets the reference in [pos-window, pos+window), trying the chromosome with and without a
+// "chr" prefix. pos is 0-based, as the break points are when they are used to query the bam, and
+// the window starts at the 0-based start of its sequence.
+func refWindow(fa *faidx.Faidx, chrom string, pos, window int) (assembly.Window, error) {
+	start := pos - window
+	if start < 0 {
+		start = 0
+	}
+	seq, err := fa.Get(chrom, start, pos+window)
+	if err != nil {
+		alt := "chr" + chrom
+		if strings.HasPrefix(chrom, "chr") {
+			alt = chrom[3:]
+		}
+		if seq, err = fa.Get(alt, start, pos+window); err != nil {
+			return assembly.Window{}, err
+		}
+	}
+	return assembly.Window{Chrom: chrom, Start: start, Seq: seq}, nil
+}
+
+// AssembleSvJunctions assembles the reads around both break points of bpPair and aligns the
+// contigs back to the reference from fastaFile to find the exact junctions.
+func AssembleSvJunctions(bamFile, fastaFile string, bpPair db.SvBpPair) ([]*assembly.Junction, error) {
+	bh, err := os.Open(bamFile)
+	if err != nil {
+		return nil, err
+	}
+	defer bh.Close()
+	bamReader := seekBamReader(bh)
+	defer bamReader.Close()
+	idx := createBaiReader(getBaiFromBamPath(bamFile))
+	window := getSvWindowFromBamPath(bamFile)
+
+	var windows []assembly.Window
+	for _, bp := range []struct {
+		chrom string
+		pos   int
+	}{{bpPair.Chr1, bpPair.Bp1}, {bpPair.Chr2, bpPair.Bp2}} {
+		w, err := cachedRefWindow(fastaFile, bp.chrom, bp.pos, window)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+
+	// a read is seen from both windows if they overlap.
+	seen := make(map[string]bool)
+	var reads [][]byte
+	err = forEachSvRecord(bamReader, idx, bpPair, window, func(r *sam.Record, mateID, matePos int) error {
+		if !isBreakPointRead(r, mateID, matePos, window) {
+			return nil
+		}
+		key := r.Name + "/" + r.Flags.String() + "/" + r.Cigar.String()
+		if !seen[key] {
+			seen[key] = true
+			reads = append(reads, r.Seq.Expand())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return assembly.Junctions(reads, windows, assembly.DefaultOptions), nil
+}
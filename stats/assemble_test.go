@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/Schaudge/ngsutils/db"
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/faidx"
+)
+
+func randomSeq(r *rand.Rand, n int) string {
+	s := make([]byte, n)
+	for i := range s {
+		s[i] = "ACGT"[r.Intn(4)]
+	}
+	return string(s)
+}
+
+// writeFasta writes the sequences, each on one line, to path with a .fai next to it.
+func writeFasta(t *testing.T, path string, names []string, seqs []string) {
+	t.Helper()
+	var fa, fai strings.Builder
+	for i, name := range names {
+		fmt.Fprintf(&fa, ">%s\n", name)
+		fmt.Fprintf(&fai, "%s\t%d\t%d\t%d\t%d\n", name, len(seqs[i]), fa.Len(), len(seqs[i]), len(seqs[i])+1)
+		fmt.Fprintf(&fa, "%s\n", seqs[i])
+	}
+	if err := os.WriteFile(path, []byte(fa.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".fai", []byte(fai.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeBam writes the records, sorted by position, to path with a .bai next to it.
+func writeBam(t *testing.T, path string, h *sam.Header, recs []*sam.Record) {
+	t.Helper()
+	sort.SliceStable(recs, func(i, j int) bool {
+		if recs[i].Ref.ID() != recs[j].Ref.ID() {
+			return recs[i].Ref.ID() < recs[j].Ref.ID()
+		}
+		return recs[i].Pos < recs[j].Pos
+	})
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bw, err := bam.NewWriter(f, h, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recs {
+		if err := bw.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// index the chunks the records were written to.
+	if f, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	br, err := bam.NewReader(f, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var idx bam.Index
+	for {
+		r, err := br.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := idx.Add(r, br.LastChunk()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bai, err := os.Create(path + ".bai")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bam.WriteIndex(bai, &idx); err != nil {
+		t.Fatal(err)
+	}
+	if err := bai.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newRecord(t *testing.T, ref, mate *sam.Reference, pos, matePos int, cigar string, seq string, flags sam.Flags) *sam.Record {
+	t.Helper()
+	c, err := sam.ParseCigar([]byte(cigar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := sam.NewRecord(fmt.Sprintf("r%d", pos), ref, mate, pos, matePos, 0, 60, c, []byte(seq), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Flags = flags
+	return r
+}
+
+func TestIsBreakPointRead(t *testing.T) {
+	ref1, _ := sam.NewReference("1", "", "", 10000, nil, nil)
+	ref2, _ := sam.NewReference("2", "", "", 10000, nil, nil)
+	if _, err := sam.NewHeader(nil, []*sam.Reference{ref1, ref2}); err != nil {
+		t.Fatal(err)
+	}
+	seq := strings.Repeat("A", 20)
+	sa := newRecord(t, ref1, ref1, 100, 300, "20M", seq, sam.Paired)
+	aux, err := sam.NewAux(sam.NewTag("SA"), "2,5000,+,10M10S,60,0;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sa.AuxFields = append(sa.AuxFields, aux)
+	for _, tc := range []struct {
+		name string
+		r    *sam.Record
+		want bool
+	}{
+		{"plain", newRecord(t, ref1, ref1, 100, 300, "20M", seq, sam.Paired), false},
+		{"clipped", newRecord(t, ref1, ref1, 100, 300, "5S15M", seq, sam.Paired), true},
+		{"clipped at the end", newRecord(t, ref1, ref1, 100, 300, "15M5S", seq, sam.Paired), true},
+		{"short clip", newRecord(t, ref1, ref1, 100, 300, "4S16M", seq, sam.Paired), false},
+		{"mate near", newRecord(t, ref1, ref2, 100, 5100, "20M", seq, sam.Paired), true},
+		{"mate far", newRecord(t, ref1, ref2, 100, 5600, "20M", seq, sam.Paired), false},
+		{"supplementary", sa, true},
+		{"duplicate", newRecord(t, ref1, ref1, 100, 300, "5S15M", seq, sam.Paired|sam.Duplicate), false},
+		{"secondary", newRecord(t, ref1, ref2, 100, 5000, "20M", seq, sam.Paired|sam.Secondary), false},
+	} {
+		if got := isBreakPointRead(tc.r, ref2.ID(), 5000, 500); got != tc.want {
+			t.Errorf("isBreakPointRead() of %s read = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRefWindow(t *testing.T) {
+	seq := randomSeq(rand.New(rand.NewSource(3)), 200)
+	path := filepath.Join(t.TempDir(), "ref.fa")
+	writeFasta(t, path, []string{"chr1"}, []string{seq})
+	fa, err := faidx.New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fa.Close()
+
+	// pos is 0-based and the chromosome is found without its "chr" prefix.
+	w, err := refWindow(fa, "1", 100, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.Chrom != "1" || w.Start != 90 || w.Seq != seq[90:110] {
+		t.Fatalf("refWindow() = %+v, want the window at 90 of 1", w)
+	}
+	// the window is clipped at the start of the chromosome.
+	if w, err = refWindow(fa, "chr1", 5, 10); err != nil || w.Start != 0 || w.Seq != seq[:15] {
+		t.Fatalf("refWindow() = %+v, %v", w, err)
+	}
+	if _, err := refWindow(fa, "2", 100, 10); err == nil {
+		t.Fatal("expected an error for a chromosome that isn't in the fasta")
+	}
+}
+
+func TestAssembleSvJunctions(t *testing.T) {
+	r := rand.New(rand.NewSource(7))
+	a, b := []byte(randomSeq(r, 2000)), []byte(randomSeq(r, 2000))
+	// make sure the sides of the junction differ so that there's no microhomology by chance.
+	b[1100] = "CGTA"[strings.IndexByte("ACGT", a[1000])]
+	a[999] = "CGTA"[strings.IndexByte("ACGT", b[1099])]
+	dir := t.TempDir()
+	fasta := filepath.Join(dir, "ref.fa")
+	writeFasta(t, fasta, []string{"chr1", "chr2"}, []string{string(a), string(b)})
+
+	ref1, _ := sam.NewReference("1", "", "", len(a), nil, nil)
+	ref2, _ := sam.NewReference("2", "", "", len(b), nil, nil)
+	h, err := sam.NewHeader(nil, []*sam.Reference{ref1, ref2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SortOrder = sam.Coordinate
+
+	// reads every 4 bases across the fusion of 1:800-1000 and 2:1100-1300, soft-clipped at the
+	// junction and aligned to the side that most of their bases come from.
+	fused := string(a[800:1000]) + string(b[1100:1300])
+	var recs []*sam.Record
+	for i := 0; i+100 <= len(fused); i += 4 {
+		seq := fused[i : i+100]
+		left := 200 - i
+		switch {
+		case left >= 100:
+			recs = append(recs, newRecord(t, ref1, ref1, 800+i, 1200+i, "100M", seq, sam.Paired))
+		case left >= 50:
+			recs = append(recs, newRecord(t, ref1, ref1, 800+i, 1200+i, fmt.Sprintf("%dM%dS", left, 100-left), seq, sam.Paired))
+		case left > 0:
+			recs = append(recs, newRecord(t, ref2, ref2, 1100, 1400, fmt.Sprintf("%dS%dM", left, 100-left), seq, sam.Paired))
+		default:
+			recs = append(recs, newRecord(t, ref2, ref2, 1100-left, 1400, "100M", seq, sam.Paired))
+		}
+	}
+	bamFile := filepath.Join(dir, "s.bam")
+	writeBam(t, bamFile, h, recs)
+
+	js, err := AssembleSvJunctions(bamFile, fasta, db.SvBpPair{Chr1: "1", Bp1: 1000, Chr2: "2", Bp2: 1100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(js) != 1 {
+		t.Fatalf("expected 1 junction, got %d", len(js))
+	}
+	j := js[0]
+	// the windows start 500 bases before the 0-based break points, so the junction is at the
+	// last base of 1:800-1000 and the first of 2:1100-1300, 1-based.
+	if j.Chrom1 == "2" {
+		if j.Chrom2 != "1" || j.Pos1 != 1101 || j.Strand1 != -1 || j.Pos2 != 1000 || j.Strand2 != -1 {
+			t.Fatalf("got %s", j)
+		}
+	} else if j.Chrom2 != "2" || j.Pos1 != 1000 || j.Strand1 != 1 || j.Pos2 != 1101 || j.Strand2 != 1 {
+		t.Fatalf("got %s", j)
+	}
+	if j.Support == 0 {
+		t.Fatalf("no reads support %s", j)
+	}
+}
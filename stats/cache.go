@@ -0,0 +1,160 @@
+package stats
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Schaudge/ngsutils/assembly"
+	"github.com/Schaudge/ngsutils/utils"
+	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/bgzf"
+	"github.com/biogo/hts/sam"
+	"github.com/brentp/faidx"
+)
+
+// Break point queries are usually made in batches over the same bams and reference, so the
+// decoded indexes, the chunks they give for each window and the reference windows are cached.
+const (
+	baiCacheSize    = 16
+	chunkCacheSize  = 4096
+	fastaCacheSize  = 4
+	windowCacheSize = 1024
+)
+
+// baiKey identifies a bai by its path and modification time, so a re-indexed bam is read again.
+type baiKey struct {
+	path string
+	mod  time.Time
+}
+
+// baiIndex is a decoded bai with the key it is cached by.
+type baiIndex struct {
+	*bam.Index
+	key baiKey
+}
+
+// chunkKey is keyed by the bai rather than the decoded index, so the chunks of an index that was
+// evicted and read again are still found.
+type chunkKey struct {
+	bai        baiKey
+	ref        int
+	start, end int
+}
+
+type windowKey struct {
+	fasta       string
+	chrom       string
+	pos, window int
+}
+
+var (
+	baiCache    = utils.NewLRU[baiKey, *baiIndex](baiCacheSize, nil)
+	chunkCache  = utils.NewLRU[chunkKey, []bgzf.Chunk](chunkCacheSize, nil)
+	fastaCache  = utils.NewLRU[string, *sharedFasta](fastaCacheSize, func(_ string, f *sharedFasta) { f.evict() })
+	windowCache = utils.NewLRU[windowKey, assembly.Window](windowCacheSize, nil)
+)
+
+// readBai decodes the bai at baiFile or returns it from the cache.
+func readBai(baiFile string) (*baiIndex, error) {
+	fi, err := os.Stat(baiFile)
+	if err != nil {
+		return nil, err
+	}
+	key := baiKey{baiFile, fi.ModTime()}
+	return baiCache.GetOrLoad(key, func() (*baiIndex, error) {
+		fh, err := ioutil.ReadFile(baiFile) // auto open/close file
+		if err != nil {
+			return nil, err
+		}
+		idx, err := bam.ReadIndex(bytes.NewReader(fh))
+		if err != nil {
+			return nil, err
+		}
+		return &baiIndex{Index: idx, key: key}, nil
+	})
+}
+
+// indexChunks gives the chunks of the bam that hold [start, end) of ref.
+func indexChunks(idx *baiIndex, ref *sam.Reference, start, end int) ([]bgzf.Chunk, error) {
+	return chunkCache.GetOrLoad(chunkKey{idx.key, ref.ID(), start, end}, func() ([]bgzf.Chunk, error) {
+		return idx.Chunks(ref, start, end)
+	})
+}
+
+// sharedFasta is a cached fasta with a count of its users. A fasta that is evicted while it is
+// used is closed by its last user.
+type sharedFasta struct {
+	*faidx.Faidx
+	mu              sync.Mutex
+	users           int
+	evicted, closed bool
+}
+
+// acquire adds a user and reports whether the fasta is still open.
+func (f *sharedFasta) acquire() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.users++
+	return true
+}
+
+func (f *sharedFasta) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.users--
+	f.closeUnused()
+}
+
+func (f *sharedFasta) evict() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.evicted = true
+	f.closeUnused()
+}
+
+func (f *sharedFasta) closeUnused() {
+	if f.evicted && f.users == 0 && !f.closed {
+		f.closed = true
+		f.Close()
+	}
+}
+
+// withFasta calls fn with the indexed fasta at path, which is opened once and cached. It is not
+// closed while fn runs, even if it is evicted, but fn must not keep it.
+func withFasta(path string, fn func(fa *faidx.Faidx) error) error {
+	for {
+		f, err := fastaCache.GetOrLoad(path, func() (*sharedFasta, error) {
+			fa, err := faidx.New(path)
+			if err != nil {
+				return nil, err
+			}
+			return &sharedFasta{Faidx: fa}, nil
+		})
+		if err != nil {
+			return err
+		}
+		// a fasta that was closed after it was looked up is opened again.
+		if f.acquire() {
+			defer f.release()
+			return fn(f.Faidx)
+		}
+	}
+}
+
+// cachedRefWindow is refWindow for the fasta at path with the windows cached.
+func cachedRefWindow(path string, chrom string, pos, window int) (assembly.Window, error) {
+	return windowCache.GetOrLoad(windowKey{path, chrom, pos, window}, func() (assembly.Window, error) {
+		var w assembly.Window
+		err := withFasta(path, func(fa *faidx.Faidx) (err error) {
+			w, err = refWindow(fa, chrom, pos, window)
+			return err
+		})
+		return w, err
+	})
+}
@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/brentp/faidx"
+)
+
+func TestFastaCacheEviction(t *testing.T) {
+	fastaCache.Purge()
+	defer fastaCache.Purge()
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i <= fastaCacheSize; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("%d.fa", i))
+		writeFasta(t, p, []string{"chr1"}, []string{"ACGTACGTAC"})
+		paths = append(paths, p)
+	}
+	noop := func(*faidx.Faidx) error { return nil }
+
+	var first *sharedFasta
+	err := withFasta(paths[0], func(fa *faidx.Faidx) error {
+		first, _ = fastaCache.Peek(paths[0])
+		// the others push the first out of the cache while it is used.
+		for _, p := range paths[1:] {
+			if err := withFasta(p, noop); err != nil {
+				return err
+			}
+		}
+		if _, ok := fastaCache.Peek(paths[0]); ok || !first.evicted {
+			t.Fatal("the first fasta was not evicted")
+		}
+		if first.closed {
+			t.Fatal("a fasta was closed while it was used")
+		}
+		_, err := fa.Get("chr1", 2, 6)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.closed {
+		t.Fatal("an evicted fasta was not closed by its last user")
+	}
+
+	// it is opened again when it is next used.
+	if err := withFasta(paths[0], noop); err != nil {
+		t.Fatal(err)
+	}
+	if again, ok := fastaCache.Peek(paths[0]); !ok || again == first || again.closed {
+		t.Fatal("the evicted fasta was not opened again")
+	}
+	// a fasta that is not used is closed when it is evicted.
+	second, _ := fastaCache.Peek(paths[1])
+	if second != nil {
+		t.Fatal("the second fasta should have been evicted by the first")
+	}
+	third, _ := fastaCache.Peek(paths[2])
+	fastaCache.Remove(paths[2])
+	if !third.closed {
+		t.Fatal("an unused fasta was not closed when it was evicted")
+	}
+
+	if err := withFasta(filepath.Join(dir, "missing.fa"), noop); err == nil {
+		t.Fatal("expected an error for a missing fasta")
+	}
+}
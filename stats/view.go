@@ -21,17 +21,17 @@
 package stats
 
 import (
-	"bytes"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/Schaudge/ngsutils/db"
+	"github.com/Schaudge/ngsutils/insertsize"
 	"github.com/Schaudge/ngsutils/utils"
 	"github.com/biogo/hts/bam"
+	"github.com/biogo/hts/sam"
 )
 
 func panicError(err error) {
@@ -61,11 +61,24 @@ func getBaiFromBamPath(bamFile string) string {
 	}
 }
 
-// createBaiReader creates a BAI reader from file path.
-func createBaiReader(baiFile string) *bam.Index {
-	fh, err := ioutil.ReadFile(baiFile) // auto open/close file
-	panicError(err)
-	reader, err := bam.ReadIndex(bytes.NewReader(fh))
+// defaultSvWindow is the distance around a break point in which records are collected when
+// there are no insert-size estimates for the bam.
+const defaultSvWindow = 500
+
+// getSvWindowFromBamPath gives the discordant distance from the insert-size estimates saved
+// next to the bam (see insertsize.FindModel), or defaultSvWindow.
+func getSvWindowFromBamPath(bamFile string) int {
+	if modelFile := insertsize.FindModel(bamFile); modelFile != "" {
+		if m, err := insertsize.Load(modelFile); err == nil {
+			return m.Distance("")
+		}
+	}
+	return defaultSvWindow
+}
+
+// createBaiReader creates a BAI reader from file path. Decoded indexes are cached.
+func createBaiReader(baiFile string) *baiIndex {
+	reader, err := readBai(baiFile)
 	panicError(err)
 	return reader
 }
@@ -84,7 +97,7 @@ func BamViewOnRegion(bamFile string, id, start, end int) error {
 	idx := createBaiReader(getBaiFromBamPath(bamFile))
 
 	ref := bamReader.Header().Refs()[id]
-	chunks, err := idx.Chunks(ref, start, end)
+	chunks, err := indexChunks(idx, ref, start, end)
 	panicError(err)
 	i, err := bam.NewIterator(bamReader, chunks)
 	panicError(err)
@@ -117,6 +130,7 @@ func ExtractSvSamSet(bamFile string, bpPair db.SvBpPair) error {
 	}(bh)
 	bamReader := seekBamReader(bh)
 	idx := createBaiReader(getBaiFromBamPath(bamFile))
+	window := getSvWindowFromBamPath(bamFile)
 
 	// output bam file settings
 	ob, err := os.Create(outBamFile)
@@ -135,6 +149,22 @@ func ExtractSvSamSet(bamFile string, bpPair db.SvBpPair) error {
 		}
 	}(bw)
 
+	err = forEachSvRecord(bamReader, idx, bpPair, window, func(r *sam.Record, mateID, matePos int) error {
+		if r.MateRef.ID() == mateID && matePos-window < r.MatePos && r.MatePos < matePos+window {
+			return bw.Write(r)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return bamReader.Close()
+}
+
+// forEachSvRecord calls fn for each record within window of either break point, passing the
+// reference id and position of the other break point.
+func forEachSvRecord(bamReader *bam.Reader, idx *baiIndex, bpPair db.SvBpPair, window int, fn func(r *sam.Record, mateID, matePos int) error) error {
 	chr1, chr2 := utils.CtgName2Id(bpPair.Chr1), utils.CtgName2Id(bpPair.Chr2)
 	orderedBpPair := [][]int{
 		[]int{chr1, bpPair.Bp1, chr2, bpPair.Bp2},
@@ -146,22 +176,19 @@ func ExtractSvSamSet(bamFile string, bpPair db.SvBpPair) error {
 
 	for _, bp := range orderedBpPair {
 		ref := bamReader.Header().Refs()[bp[0]]
-		chunks, err := idx.Chunks(ref, bp[1]-500, bp[1]+500)
+		chunks, err := indexChunks(idx, ref, bp[1]-window, bp[1]+window)
 		panicError(err)
 		i, err := bam.NewIterator(bamReader, chunks)
 		panicError(err)
 		for i.Next() {
-			r := i.Record()
-			if r.MateRef.ID() == bp[2] && bp[3]-500 < r.MatePos && r.MatePos < bp[3]+500 {
-				//				sam, _ := i.Record().MarshalText()
-				//				fmt.Printf("%s\n", sam)
-				err := bw.Write(r)
-				if err != nil {
-					return err
-				}
+			if err := fn(i.Record(), bp[2], bp[3]); err != nil {
+				i.Close()
+				return err
 			}
 		}
+		if err := i.Close(); err != nil {
+			return err
+		}
 	}
-
-	return bamReader.Close()
+	return nil
 }
@@ -0,0 +1,176 @@
+package utils
+
+import "sync"
+
+// LRU is a cache that holds at most a fixed number of entries, evicting the least recently used
+// entry to make room for a new one. It is safe for concurrent use.
+type LRU[K comparable, V any] struct {
+	mu       sync.Mutex
+	capacity int
+	onEvict  func(K, V)
+	// om is ordered from the least to the most recently used entry.
+	om    Map[K, V]
+	stats LRUStats
+	// loading has the loads of GetOrLoad that are running.
+	loading map[K]*lruLoad[V]
+}
+
+// lruLoad is a load that other callers of GetOrLoad for the same key wait for.
+type lruLoad[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+}
+
+// LRUStats counts the lookups of an LRU.
+type LRUStats struct {
+	Hits, Misses, Evictions uint64
+}
+
+// NewLRU creates a cache for capacity entries; a capacity of 0 or less is unbounded. onEvict, if
+// not nil, is called with each entry that is evicted to make room, replaced, removed or purged,
+// for example to close a file.
+func NewLRU[K comparable, V any](capacity int, onEvict func(key K, value V)) *LRU[K, V] {
+	return &LRU[K, V]{capacity: capacity, onEvict: onEvict}
+}
+
+// Get returns the value for key and marks it as recently used.
+func (c *LRU[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.om.Get(key)
+	if ok {
+		c.stats.Hits++
+		c.om.MoveToBack(key)
+	} else {
+		c.stats.Misses++
+	}
+	return v, ok
+}
+
+// Peek returns the value for key without marking it as used or counting the lookup.
+func (c *LRU[K, V]) Peek(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.om.Get(key)
+}
+
+// Add adds or replaces the value for key, marks it as recently used and evicts the least
+// recently used entry if the cache is over capacity. A replaced value is passed to onEvict. It
+// reports whether an entry was evicted to make room.
+func (c *LRU[K, V]) Add(key K, value V) bool {
+	c.mu.Lock()
+	evicted, replaced := c.add(key, value)
+	c.mu.Unlock()
+	c.notifyEvicted(replaced, evicted)
+	return evicted != nil
+}
+
+// add is Add with the lock held. It returns the entries that onEvict must be called with.
+func (c *LRU[K, V]) add(key K, value V) (evicted, replaced *Entry[K, V]) {
+	if old, present := c.om.Set(key, value); present {
+		c.om.MoveToBack(key)
+		return nil, &Entry[K, V]{Key: key, Value: old}
+	}
+	if c.capacity > 0 && c.om.Len() > c.capacity {
+		evicted = c.om.Oldest()
+		c.om.Delete(evicted.Key)
+		c.stats.Evictions++
+	}
+	return evicted, nil
+}
+
+// notifyEvicted calls onEvict with the entries that are not nil. The lock must not be held.
+func (c *LRU[K, V]) notifyEvicted(entries ...*Entry[K, V]) {
+	if c.onEvict == nil {
+		return
+	}
+	for _, e := range entries {
+		if e != nil {
+			c.onEvict(e.Key, e.Value)
+		}
+	}
+}
+
+// GetOrLoad returns the value for key, calling load and adding its value on a miss. Errors from
+// load are returned and nothing is cached. The lock is not held while load runs; concurrent
+// misses on the same key wait for a single call of load and share its result.
+func (c *LRU[K, V]) GetOrLoad(key K, load func() (V, error)) (V, error) {
+	c.mu.Lock()
+	if v, ok := c.om.Get(key); ok {
+		c.stats.Hits++
+		c.om.MoveToBack(key)
+		c.mu.Unlock()
+		return v, nil
+	}
+	c.stats.Misses++
+	if l, ok := c.loading[key]; ok {
+		c.mu.Unlock()
+		<-l.done
+		return l.value, l.err
+	}
+	l := &lruLoad[V]{done: make(chan struct{})}
+	if c.loading == nil {
+		c.loading = make(map[K]*lruLoad[V])
+	}
+	c.loading[key] = l
+	c.mu.Unlock()
+
+	l.value, l.err = load()
+	var evicted, replaced *Entry[K, V]
+	c.mu.Lock()
+	delete(c.loading, key)
+	if l.err == nil {
+		evicted, replaced = c.add(key, l.value)
+	}
+	c.mu.Unlock()
+	close(l.done)
+	c.notifyEvicted(replaced, evicted)
+	return l.value, l.err
+}
+
+// Remove removes key from the cache and reports whether it was present.
+func (c *LRU[K, V]) Remove(key K) bool {
+	c.mu.Lock()
+	v, present := c.om.Delete(key)
+	c.mu.Unlock()
+	if present && c.onEvict != nil {
+		c.onEvict(key, v)
+	}
+	return present
+}
+
+// Purge removes all entries.
+func (c *LRU[K, V]) Purge() {
+	c.mu.Lock()
+	old := c.om
+	c.om = Map[K, V]{}
+	c.mu.Unlock()
+	if c.onEvict != nil {
+		old.Range(func(k K, v V) bool {
+			c.onEvict(k, v)
+			return true
+		})
+	}
+}
+
+// Len is the number of entries in the cache.
+func (c *LRU[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.om.Len()
+}
+
+// Keys returns the keys from the least to the most recently used.
+func (c *LRU[K, V]) Keys() []K {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.om.Keys()
+}
+
+// Stats returns the hits, misses and evictions so far.
+func (c *LRU[K, V]) Stats() LRUStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	var evicted []int
+	c := NewLRU[int, string](2, func(k int, _ string) { evicted = append(evicted, k) })
+	c.Add(1, "a")
+	c.Add(2, "b")
+	if v, ok := c.Get(1); !ok || v != "a" {
+		t.Fatalf("Get(1) = %q, %v", v, ok)
+	}
+	// 2 is now the least recently used.
+	if !c.Add(3, "c") {
+		t.Fatal("Add over capacity did not evict")
+	}
+	if _, ok := c.Get(2); ok {
+		t.Fatal("2 was not evicted")
+	}
+	if got := c.Keys(); !reflect.DeepEqual(got, []int{1, 3}) {
+		t.Fatalf("Keys() = %v", got)
+	}
+	if c.Add(3, "C") {
+		t.Fatal("replacing a value evicted an entry")
+	}
+	if v, _ := c.Peek(3); v != "C" {
+		t.Fatalf("Peek(3) = %q", v)
+	}
+	if !c.Remove(1) || c.Remove(1) {
+		t.Fatal("Remove(1) is wrong")
+	}
+	c.Purge()
+	// the value of 3 that was replaced is evicted too.
+	if c.Len() != 0 || !reflect.DeepEqual(evicted, []int{2, 3, 1, 3}) {
+		t.Fatalf("evicted %v, %d left", evicted, c.Len())
+	}
+	if got, want := c.Stats(), (LRUStats{Hits: 1, Misses: 1, Evictions: 1}); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestLRUGetOrLoad(t *testing.T) {
+	c := NewLRU[string, int](0, nil)
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		return 42, nil
+	}
+	for i := 0; i < 3; i++ {
+		if v, err := c.GetOrLoad("x", load); err != nil || v != 42 {
+			t.Fatalf("GetOrLoad = %d, %v", v, err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loaded %d times", loads)
+	}
+	fail := errors.New("fail")
+	if _, err := c.GetOrLoad("y", func() (int, error) { return 0, fail }); err != fail {
+		t.Fatalf("GetOrLoad returned %v", err)
+	}
+	if _, ok := c.Peek("y"); ok {
+		t.Fatal("a failed load was cached")
+	}
+}
+
+func TestLRUGetOrLoadOnce(t *testing.T) {
+	var mu sync.Mutex
+	var evicted []int
+	c := NewLRU[string, *int](1, func(_ string, v *int) {
+		mu.Lock()
+		evicted = append(evicted, *v)
+		mu.Unlock()
+	})
+	// concurrent misses on a key share one load.
+	start := make(chan struct{})
+	loads := 0
+	var wg sync.WaitGroup
+	got := make([]*int, 8)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			v, err := c.GetOrLoad("x", func() (*int, error) {
+				<-start
+				loads++
+				n := 1
+				return &n, nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+			got[i] = v
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	if loads != 1 {
+		t.Fatalf("loaded %d times", loads)
+	}
+	for _, v := range got {
+		if v != got[0] {
+			t.Fatal("GetOrLoad gave different values for one key")
+		}
+	}
+	n := 2
+	c.GetOrLoad("y", func() (*int, error) { return &n, nil })
+	if !reflect.DeepEqual(evicted, []int{1}) {
+		t.Fatalf("evicted %v", evicted)
+	}
+}
+
+func TestSyncMapConcurrent(t *testing.T) {
+	m := NewSyncMap[int, int]()
+	c := NewLRU[int, int](50, nil)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				m.Store(g*100+i, i)
+				m.Load(i)
+				c.Add(g*100+i, i)
+				c.Get(i)
+				if i%10 == 0 {
+					m.Range(func(k, v int) bool { return k < 50 })
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if m.Len() != 800 || len(m.Keys()) != 800 {
+		t.Fatalf("Len() = %d", m.Len())
+	}
+	if c.Len() != 50 || c.Stats().Evictions != 750 {
+		t.Fatalf("LRU has %d entries after %d evictions", c.Len(), c.Stats().Evictions)
+	}
+	if v, loaded := m.LoadOrStore(5, -1); !loaded || v != 5 {
+		t.Fatalf("LoadOrStore(5) = %d, %v", v, loaded)
+	}
+	if v, loaded := m.LoadOrStore(-1, -1); loaded || v != -1 {
+		t.Fatalf("LoadOrStore(-1) = %d, %v", v, loaded)
+	}
+}
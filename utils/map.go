@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"fmt"
+)
+
+// Entry is a key-value pair of a Map.
+type Entry[K comparable, V any] struct {
+	Key   K
+	Value V
+
+	prev, next *Entry[K, V]
+}
+
+// Map is the generic form of OrderedMap: a map that keeps its pairs in insertion order, which can
+// be changed with the Move methods. The zero value is an empty map ready to use.
+type Map[K comparable, V any] struct {
+	pairs map[K]*Entry[K, V]
+	// oldest and newest are the ends of a doubly linked list of the pairs.
+	oldest, newest *Entry[K, V]
+}
+
+// NewMap creates a new Map.
+func NewMap[K comparable, V any]() *Map[K, V] {
+	return &Map[K, V]{pairs: make(map[K]*Entry[K, V])}
+}
+
+// Get looks for the given key, and returns the value associated with it,
+// or the zero value if not found. The boolean it returns says whether the key is present in the map.
+func (om *Map[K, V]) Get(key K) (V, bool) {
+	if pair, present := om.pairs[key]; present {
+		return pair.Value, present
+	}
+	var zero V
+	return zero, false
+}
+
+func (om *Map[K, V]) Load(key K) (V, bool) {
+	return om.Get(key)
+}
+
+// GetPair looks for the given key, and returns the pair associated with it,
+// or nil if not found. The Entry can then be used to iterate over the ordered map
+// from that point, either forward or backward.
+func (om *Map[K, V]) GetPair(key K) *Entry[K, V] {
+	return om.pairs[key]
+}
+
+// Set sets the key-value pair, and returns what `Get` would have returned
+// on that key prior to the call to `Set`.
+func (om *Map[K, V]) Set(key K, value V) (V, bool) {
+	if pair, present := om.pairs[key]; present {
+		oldValue := pair.Value
+		pair.Value = value
+		return oldValue, true
+	}
+	if om.pairs == nil {
+		om.pairs = make(map[K]*Entry[K, V])
+	}
+
+	pair := &Entry[K, V]{
+		Key:   key,
+		Value: value,
+	}
+	om.pushBack(pair)
+	om.pairs[key] = pair
+
+	var zero V
+	return zero, false
+}
+
+func (om *Map[K, V]) Store(key K, value V) (V, bool) {
+	return om.Set(key, value)
+}
+
+// Delete removes the key-value pair, and returns what `Get` would have returned
+// on that key prior to the call to `Delete`.
+func (om *Map[K, V]) Delete(key K) (V, bool) {
+	if pair, present := om.pairs[key]; present {
+		om.unlink(pair)
+		delete(om.pairs, key)
+		return pair.Value, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Len returns the length of the ordered map.
+func (om *Map[K, V]) Len() int {
+	return len(om.pairs)
+}
+
+// Oldest returns a pointer to the oldest pair. It's meant to be used to iterate on the ordered map's
+// pairs from the oldest to the newest, e.g.:
+// for pair := m.Oldest(); pair != nil; pair = pair.Next() { fmt.Printf("%v => %v\n", pair.Key, pair.Value) }
+func (om *Map[K, V]) Oldest() *Entry[K, V] {
+	return om.oldest
+}
+
+// Newest returns a pointer to the newest pair. It's meant to be used to iterate on the ordered map's
+// pairs from the newest to the oldest, e.g.:
+// for pair := m.Newest(); pair != nil; pair = pair.Prev() { fmt.Printf("%v => %v\n", pair.Key, pair.Value) }
+func (om *Map[K, V]) Newest() *Entry[K, V] {
+	return om.newest
+}
+
+// Next returns a pointer to the next pair.
+func (p *Entry[K, V]) Next() *Entry[K, V] {
+	return p.next
+}
+
+// Prev returns a pointer to the previous pair.
+func (p *Entry[K, V]) Prev() *Entry[K, V] {
+	return p.prev
+}
+
+// Keys returns the keys from the oldest to the newest.
+func (om *Map[K, V]) Keys() []K {
+	keys := make([]K, 0, om.Len())
+	for pair := om.oldest; pair != nil; pair = pair.next {
+		keys = append(keys, pair.Key)
+	}
+	return keys
+}
+
+// Values returns the values from the oldest to the newest.
+func (om *Map[K, V]) Values() []V {
+	values := make([]V, 0, om.Len())
+	for pair := om.oldest; pair != nil; pair = pair.next {
+		values = append(values, pair.Value)
+	}
+	return values
+}
+
+// Range calls f for each pair from the oldest to the newest, stopping if f returns false. f may
+// delete the pair it is called with.
+func (om *Map[K, V]) Range(f func(key K, value V) bool) {
+	for pair := om.oldest; pair != nil; {
+		next := pair.next
+		if !f(pair.Key, pair.Value) {
+			return
+		}
+		pair = next
+	}
+}
+
+func (om *Map[K, V]) pushBack(p *Entry[K, V]) {
+	p.prev, p.next = om.newest, nil
+	if om.newest != nil {
+		om.newest.next = p
+	} else {
+		om.oldest = p
+	}
+	om.newest = p
+}
+
+func (om *Map[K, V]) unlink(p *Entry[K, V]) {
+	if p.prev != nil {
+		p.prev.next = p.next
+	} else {
+		om.oldest = p.next
+	}
+	if p.next != nil {
+		p.next.prev = p.prev
+	} else {
+		om.newest = p.prev
+	}
+	p.prev, p.next = nil, nil
+}
+
+// insertAfter links p after mark, or first if mark is nil.
+func (om *Map[K, V]) insertAfter(p, mark *Entry[K, V]) {
+	if mark == nil {
+		p.prev, p.next = nil, om.oldest
+		if om.oldest != nil {
+			om.oldest.prev = p
+		} else {
+			om.newest = p
+		}
+		om.oldest = p
+		return
+	}
+	p.prev, p.next = mark, mark.next
+	if mark.next != nil {
+		mark.next.prev = p
+	} else {
+		om.newest = p
+	}
+	mark.next = p
+}
+
+func (om *Map[K, V]) getPairs(key, markKey K) (*Entry[K, V], *Entry[K, V], error) {
+	pair, present := om.pairs[key]
+	if !present {
+		return nil, nil, fmt.Errorf("error: key %v not found", key)
+	}
+	mark, present := om.pairs[markKey]
+	if !present {
+		return nil, nil, fmt.Errorf("error: mark_key %v not found", markKey)
+	}
+	return pair, mark, nil
+}
+
+func (om *Map[K, V]) MoveAfter(key K, markKey K) error {
+	pair, mark, err := om.getPairs(key, markKey)
+	if err != nil || pair == mark {
+		return err
+	}
+	om.unlink(pair)
+	om.insertAfter(pair, mark)
+	return nil
+}
+
+func (om *Map[K, V]) MoveBefore(key K, markKey K) error {
+	pair, mark, err := om.getPairs(key, markKey)
+	if err != nil || pair == mark {
+		return err
+	}
+	om.unlink(pair)
+	om.insertAfter(pair, mark.prev)
+	return nil
+}
+
+func (om *Map[K, V]) MoveToBack(key K) error {
+	pair, present := om.pairs[key]
+	if !present {
+		return fmt.Errorf("error: key %v not found", key)
+	}
+	om.unlink(pair)
+	om.pushBack(pair)
+	return nil
+}
+
+func (om *Map[K, V]) MoveToFront(key K) error {
+	pair, present := om.pairs[key]
+	if !present {
+		return fmt.Errorf("error: key %v not found", key)
+	}
+	om.unlink(pair)
+	om.insertAfter(pair, nil)
+	return nil
+}
@@ -4,7 +4,6 @@
 // All operations are constant-time.
 //
 // Github repo: https://github.com/wk8/go-ordered-map
-//
 package utils
 
 import (
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// JSON objects only have string keys, so as with a Go map the keys of a Map or OrderedMap are encoded
+// with their MarshalText method or as strings or integers.
+
+func encodeKey(key interface{}) (string, error) {
+	if tm, ok := key.(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		return string(b), err
+	}
+	rv := reflect.ValueOf(key)
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("orderedmap: unsupported JSON key type %T", key)
+}
+
+func decodeKey[K comparable](s string) (K, error) {
+	var key K
+	if tu, ok := interface{}(&key).(encoding.TextUnmarshaler); ok {
+		return key, tu.UnmarshalText([]byte(s))
+	}
+	rv := reflect.ValueOf(&key).Elem()
+	switch rv.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+		return key, nil
+	case reflect.Interface:
+		if rv.NumMethod() == 0 {
+			rv.Set(reflect.ValueOf(s))
+			return key, nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, rv.Type().Bits())
+		if err != nil {
+			return key, fmt.Errorf("orderedmap: bad JSON key %q: %w", s, err)
+		}
+		rv.SetInt(n)
+		return key, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(s, 10, rv.Type().Bits())
+		if err != nil {
+			return key, fmt.Errorf("orderedmap: bad JSON key %q: %w", s, err)
+		}
+		rv.SetUint(n)
+		return key, nil
+	}
+	return key, fmt.Errorf("orderedmap: unsupported JSON key type %s", rv.Type())
+}
+
+// MarshalJSON encodes the map as a JSON object with the keys in order.
+func (om *Map[K, V]) MarshalJSON() ([]byte, error) {
+	if om == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for pair := om.oldest; pair != nil; pair = pair.next {
+		if pair != om.oldest {
+			buf.WriteByte(',')
+		}
+		if err := writeMember(&buf, pair.Key, pair.Value); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// MarshalJSON encodes the map as a JSON object with the keys in order.
+func (om *OrderedMap) MarshalJSON() ([]byte, error) {
+	if om == nil {
+		return []byte("null"), nil
+	}
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for pair := om.Oldest(); pair != nil; pair = pair.Next() {
+		if pair.element.Prev() != nil {
+			buf.WriteByte(',')
+		}
+		if err := writeMember(&buf, pair.Key, pair.Value); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// writeMember writes the "key":value member of a JSON object.
+func writeMember(buf *bytes.Buffer, key, value interface{}) error {
+	k, err := encodeKey(key)
+	if err != nil {
+		return err
+	}
+	kb, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	vb, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	buf.Write(kb)
+	buf.WriteByte(':')
+	buf.Write(vb)
+	return nil
+}
+
+// UnmarshalJSON adds the members of a JSON object to the map in the order they appear.
+func (om *Map[K, V]) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("orderedmap: expected a JSON object, got %v", tok)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, err := decodeKey[K](tok.(string))
+		if err != nil {
+			return err
+		}
+		var value V
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		om.Set(key, value)
+	}
+	_, err = dec.Token()
+	return err
+}
+
+// MarshalYAML encodes the map as a YAML mapping with the keys in order. A nil map is an empty
+// mapping.
+func (om *Map[K, V]) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if om == nil {
+		return node, nil
+	}
+	for pair := om.oldest; pair != nil; pair = pair.next {
+		var k, v yaml.Node
+		if err := k.Encode(pair.Key); err != nil {
+			return nil, err
+		}
+		if err := v.Encode(pair.Value); err != nil {
+			return nil, err
+		}
+		node.Content = append(node.Content, &k, &v)
+	}
+	return node, nil
+}
+
+// UnmarshalYAML adds the entries of a YAML mapping to the map in the order they appear.
+func (om *Map[K, V]) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("orderedmap: expected a YAML mapping on line %d", node.Line)
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		var key K
+		if err := node.Content[i].Decode(&key); err != nil {
+			return err
+		}
+		var value V
+		if err := node.Content[i+1].Decode(&value); err != nil {
+			return err
+		}
+		om.Set(key, value)
+	}
+	return nil
+}
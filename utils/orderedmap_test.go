@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMap(t *testing.T) {
+	om := NewMap[string, int]()
+	for i, k := range []string{"c", "a", "b", "d"} {
+		if _, present := om.Set(k, i); present {
+			t.Fatalf("%s present before Set", k)
+		}
+	}
+	if old, present := om.Set("a", 10); !present || old != 1 {
+		t.Fatalf("Set(a) returned %d, %v", old, present)
+	}
+	if v, ok := om.Get("a"); !ok || v != 10 {
+		t.Fatalf("Get(a) = %d, %v", v, ok)
+	}
+	if v, ok := om.Get("x"); ok || v != 0 {
+		t.Fatalf("Get(x) = %d, %v", v, ok)
+	}
+	if err := om.MoveToFront("d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := om.MoveAfter("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := om.MoveBefore("b", "d"); err != nil {
+		t.Fatal(err)
+	}
+	if err := om.MoveToBack("x"); err == nil {
+		t.Fatal("no error moving a missing key")
+	}
+	if got, want := om.Keys(), []string{"b", "d", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys() = %v, want %v", got, want)
+	}
+	if got, want := om.Values(), []int{2, 3, 10, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Values() = %v, want %v", got, want)
+	}
+
+	om.Range(func(k string, v int) bool {
+		if v > 2 {
+			om.Delete(k)
+		}
+		return k != "a"
+	})
+	if got, want := om.Keys(), []string{"b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Keys() after Range = %v, want %v", got, want)
+	}
+	var back []string
+	for p := om.Newest(); p != nil; p = p.Prev() {
+		back = append(back, p.Key)
+	}
+	if !reflect.DeepEqual(back, []string{"c", "b"}) || om.Len() != 2 {
+		t.Fatalf("backwards iteration gave %v", back)
+	}
+
+	var zero Map[int, bool]
+	zero.Set(1, true)
+	if zero.Len() != 1 || zero.Oldest().Key != 1 {
+		t.Fatal("zero value Map is not usable")
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+	var om *OrderedMap = New()
+	om.Store("x", 1)
+	om.Store(2, "y")
+	if v, ok := om.Load(2); !ok || v.(string) != "y" {
+		t.Fatalf("Load(2) = %v, %v", v, ok)
+	}
+	var p *Pair = om.GetPair("x")
+	if p == nil || p.Next().Key != 2 {
+		t.Fatalf("GetPair(x) = %v", p)
+	}
+	b, err := json.Marshal(om)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"x":1,"2":"y"}` {
+		t.Fatalf("json.Marshal gave %s", b)
+	}
+}
+
+func TestMapJSON(t *testing.T) {
+	const s = `{"z":{"10":1,"2":2},"a":{}}`
+	var om Map[string, *Map[int, float64]]
+	if err := json.Unmarshal([]byte(s), &om); err != nil {
+		t.Fatal(err)
+	}
+	if got := om.Keys(); !reflect.DeepEqual(got, []string{"z", "a"}) {
+		t.Fatalf("Keys() = %v", got)
+	}
+	if z, _ := om.Get("z"); !reflect.DeepEqual(z.Keys(), []int{10, 2}) {
+		t.Fatalf("inner Keys() = %v", z.Keys())
+	}
+	b, err := json.Marshal(&om)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != s {
+		t.Fatalf("json.Marshal gave %s, want %s", b, s)
+	}
+	if err := json.Unmarshal([]byte(`{"x":1}`), NewMap[int, int]()); err == nil {
+		t.Fatal("no error for a bad int key")
+	}
+	if err := json.Unmarshal([]byte(`[1]`), NewMap[int, int]()); err == nil {
+		t.Fatal("no error for an array")
+	}
+}
+
+func TestMapYAML(t *testing.T) {
+	om := NewMap[string, []int]()
+	om.Set("zeta", []int{1})
+	om.Set("alpha", nil)
+	om.Set("mid", []int{2, 3})
+	b, err := yaml.Marshal(om)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back := NewMap[string, []int]()
+	if err := yaml.Unmarshal(b, back); err != nil {
+		t.Fatal(err)
+	}
+	if got := back.Keys(); !reflect.DeepEqual(got, om.Keys()) {
+		t.Fatalf("round trip gave keys %v from\n%s", got, b)
+	}
+	if v, _ := back.Get("mid"); !reflect.DeepEqual(v, []int{2, 3}) {
+		t.Fatalf("round trip gave mid = %v", v)
+	}
+
+	// a nil map is an empty mapping.
+	var nilMap *Map[string, int]
+	n, err := nilMap.MarshalYAML()
+	if node, ok := n.(*yaml.Node); err != nil || !ok || node.Kind != yaml.MappingNode || len(node.Content) != 0 {
+		t.Fatalf("MarshalYAML() of a nil map = %v, %v", n, err)
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	gzip "github.com/klauspost/compress/gzip"
+)
+
+// Reader is a buffered reader of a file that is decompressed if it is gzipped.
+type Reader struct {
+	*bufio.Reader
+	closers []io.Closer
+}
+
+// Close closes the decompressor, if any, and the file.
+func (r *Reader) Close() error {
+	var err error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		if cerr := r.closers[i].Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// Ropen opens path for reading, or stdin for "-". A gzipped or bgzipped file is found by its magic
+// bytes, whatever its name, and decompressed.
+func Ropen(path string) (*Reader, error) {
+	var f *os.File
+	if path == "-" {
+		f = os.Stdin
+	} else {
+		var err error
+		if f, err = os.Open(path); err != nil {
+			return nil, err
+		}
+	}
+	r := &Reader{Reader: bufio.NewReader(f)}
+	if f != os.Stdin {
+		r.closers = append(r.closers, f)
+	}
+	magic, err := r.Peek(2)
+	if err != nil && err != io.EOF {
+		r.Close()
+		return nil, err
+	}
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(r.Reader)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.Reader = bufio.NewReader(gz)
+		r.closers = append(r.closers, gz)
+	}
+	return r, nil
+}
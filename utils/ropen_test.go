@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	gzip "github.com/klauspost/compress/gzip"
+)
+
+func TestRopen(t *testing.T) {
+	dir := t.TempDir()
+	want := "chr1\t1\t2\nchr2\t3\t4\n"
+	plain := filepath.Join(dir, "a.bed")
+	if err := os.WriteFile(plain, []byte(want), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the gzipped file is found by its content, not its name.
+	var zb bytes.Buffer
+	zw := gzip.NewWriter(&zb)
+	zw.Write([]byte(want))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	gz := filepath.Join(dir, "b.bed")
+	if err := os.WriteFile(gz, zb.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "c.bed")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, w := range map[string]string{plain: want, gz: want, empty: ""} {
+		r, err := Ropen(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != w {
+			t.Errorf("Ropen(%s) read %q, want %q", path, got, w)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Ropen(filepath.Join(dir, "missing.bed")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,131 @@
+package utils
+
+import "sync"
+
+// SyncMap is a Map that is safe for concurrent use. Pairs are not exposed, since
+// walking them would race with writers; use Keys, Values or Range instead.
+type SyncMap[K comparable, V any] struct {
+	mu sync.RWMutex
+	om Map[K, V]
+}
+
+// NewSyncMap creates a new SyncMap. The zero value is also ready to use.
+func NewSyncMap[K comparable, V any]() *SyncMap[K, V] {
+	return &SyncMap[K, V]{}
+}
+
+// Get looks for the given key, and returns the value associated with it,
+// or the zero value if not found. The boolean it returns says whether the key is present in the map.
+func (m *SyncMap[K, V]) Get(key K) (V, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.om.Get(key)
+}
+
+func (m *SyncMap[K, V]) Load(key K) (V, bool) {
+	return m.Get(key)
+}
+
+// Set sets the key-value pair, and returns what `Get` would have returned
+// on that key prior to the call to `Set`.
+func (m *SyncMap[K, V]) Set(key K, value V) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.Set(key, value)
+}
+
+func (m *SyncMap[K, V]) Store(key K, value V) (V, bool) {
+	return m.Set(key, value)
+}
+
+// LoadOrStore returns the value for key if it is present. Otherwise it stores and returns value.
+// The boolean is true if the value was loaded.
+func (m *SyncMap[K, V]) LoadOrStore(key K, value V) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if v, present := m.om.Get(key); present {
+		return v, true
+	}
+	m.om.Set(key, value)
+	return value, false
+}
+
+// Delete removes the key-value pair, and returns what `Get` would have returned
+// on that key prior to the call to `Delete`.
+func (m *SyncMap[K, V]) Delete(key K) (V, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.Delete(key)
+}
+
+// Len returns the length of the ordered map.
+func (m *SyncMap[K, V]) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.om.Len()
+}
+
+// Keys returns the keys from the oldest to the newest.
+func (m *SyncMap[K, V]) Keys() []K {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.om.Keys()
+}
+
+// Values returns the values from the oldest to the newest.
+func (m *SyncMap[K, V]) Values() []V {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.om.Values()
+}
+
+// Range calls f for each pair from the oldest to the newest, stopping if f returns false. It
+// iterates over a copy of the pairs taken when it is called, so f may change the map.
+func (m *SyncMap[K, V]) Range(f func(key K, value V) bool) {
+	m.mu.RLock()
+	keys, values := m.om.Keys(), m.om.Values()
+	m.mu.RUnlock()
+	for i, k := range keys {
+		if !f(k, values[i]) {
+			return
+		}
+	}
+}
+
+func (m *SyncMap[K, V]) MoveAfter(key K, markKey K) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.MoveAfter(key, markKey)
+}
+
+func (m *SyncMap[K, V]) MoveBefore(key K, markKey K) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.MoveBefore(key, markKey)
+}
+
+func (m *SyncMap[K, V]) MoveToBack(key K) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.MoveToBack(key)
+}
+
+func (m *SyncMap[K, V]) MoveToFront(key K) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.MoveToFront(key)
+}
+
+// MarshalJSON encodes the map as a JSON object with the keys in order.
+func (m *SyncMap[K, V]) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.om.MarshalJSON()
+}
+
+// UnmarshalJSON adds the members of a JSON object to the map in the order they appear.
+func (m *SyncMap[K, V]) UnmarshalJSON(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.om.UnmarshalJSON(data)
+}